@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"smuggler/smuggler"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// progress of a scan, appended as JSON Lines as the work is done so an interrupted (or killed)
+// run loses at most the detectors that were running. a resumed run skips the finished targets
+// and detectors, their findings are written to the report again so it is complete
+
+type checkpointEntry struct {
+	Target   string             `json:"target"` // key of the target record
+	URL      string             `json:"url"`
+	Detector string             `json:"detector,omitempty"` // a detector ran to completion
+	Done     bool               `json:"done,omitempty"`     // the target was scanned, Findings has all of them
+	Findings []smuggler.Finding `json:"findings,omitempty"`
+}
+
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+
+	done      map[string][]smuggler.Finding // findings of the finished targets
+	detectors map[string][]string           // detectors that ran on the unfinished targets
+	findings  map[string][]smuggler.Finding // ...and their findings
+}
+
+// a new checkpoint at path, or the one of a previous run to resume. nil methods do nothing
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	c := &checkpoint{
+		done:      make(map[string][]smuggler.Finding),
+		detectors: make(map[string][]string),
+		findings:  make(map[string][]smuggler.Finding),
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		if err := c.load(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	var err error
+	if c.file, err = os.OpenFile(path, flags, 0644); err != nil {
+		return nil, err
+	}
+	if resume && !endsWithNewline(path) { // the entry cut by a crash stays on its own line
+		if _, err := c.file.Write([]byte("\n")); err != nil {
+			c.file.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// true for empty files
+func endsWithNewline(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil || fi.Size() == 0 {
+		return true
+	}
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, fi.Size()-1); err != nil {
+		return true
+	}
+	return b[0] == '\n'
+}
+
+func (c *checkpoint) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		var e checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || len(e.Target) == 0 {
+			log.Warn().Err(err).Msgf("%s: invalid checkpoint entry at line %d, ignored", path, line) // cut by a crash
+			continue
+		}
+		switch {
+		case e.Done:
+			c.done[e.Target] = e.Findings
+			delete(c.detectors, e.Target)
+			delete(c.findings, e.Target)
+		case len(e.Detector) > 0:
+			c.detectors[e.Target] = append(c.detectors[e.Target], e.Detector)
+			c.findings[e.Target] = append(c.findings[e.Target], e.Findings...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	log.Info().Msgf("resuming from %s: %d targets done, %d partially scanned", path, len(c.done), len(c.detectors))
+	return nil
+}
+
+// whether the target was scanned, with its findings
+func (c *checkpoint) Done(key string) ([]smuggler.Finding, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found, ok := c.done[key]
+	return found, ok
+}
+
+// detectors that already ran on the target, with their findings
+func (c *checkpoint) Partial(key string) ([]string, []smuggler.Finding) {
+	if c == nil {
+		return nil, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detectors[key], c.findings[key]
+}
+
+func (c *checkpoint) Detector(key, url, name string, found []smuggler.Finding) {
+	c.write(checkpointEntry{Target: key, URL: url, Detector: name, Findings: found})
+}
+
+func (c *checkpoint) Target(key, url string, found []smuggler.Finding) {
+	c.write(checkpointEntry{Target: key, URL: url, Done: true, Findings: found})
+}
+
+// each entry is synced, it must survive a crash of the process
+func (c *checkpoint) write(e checkpointEntry) {
+	if c == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Error().Err(err).Msg("error writing checkpoint")
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.file.Write(append(b, '\n')); err != nil {
+		log.Error().Err(err).Msg("error writing checkpoint")
+		return
+	}
+	if err := c.file.Sync(); err != nil {
+		log.Error().Err(err).Msg("error writing checkpoint")
+	}
+}
+
+func (c *checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// identifies a target record across runs: the same line of the same input gives the same key
+func (rec *hostInfo) key() string {
+	b, _ := json.Marshal(rec) // map keys are sorted
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"smuggler/smuggler"
+	"sync"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	a, b := &hostInfo{URL: "http://a.example/"}, &hostInfo{URL: "http://a.example/", Method: "PUT"}
+	if a.key() == b.key() || a.key() != (&hostInfo{URL: "http://a.example/"}).key() {
+		t.Fatal("Wanted a key per target record, the same across runs")
+	}
+	f := smuggler.Finding{Target: a.URL, Technique: smuggler.CLTE}
+
+	cp, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.Detector(a.key(), a.URL, "clte", []smuggler.Finding{f})
+	cp.Target(a.key(), a.URL, []smuggler.Finding{f})
+	cp.Detector(b.key(), b.URL, "clte", nil)
+	cp.Detector(b.key(), b.URL, "tecl", []smuggler.Finding{f})
+	cp.Close()
+
+	// a run killed while writing an entry
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"target":"` + b.key() + `","detector":"cl`)
+	file.Close()
+
+	cp, err = openCheckpoint(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, ok := cp.Done(a.key()); !ok || len(found) != 1 {
+		t.Errorf("Wanted: %s done with 1 finding, Got: %v %+v", a.URL, ok, found)
+	}
+	if _, ok := cp.Done(b.key()); ok {
+		t.Errorf("Wanted: %s not done", b.URL)
+	}
+	if dets, found := cp.Partial(b.key()); !reflect.DeepEqual(dets, []string{"clte", "tecl"}) || len(found) != 1 {
+		t.Errorf("Wanted: clte,tecl with 1 finding, Got: %v %+v", dets, found)
+	}
+	cp.Target(b.key(), b.URL, nil) // appended to the previous run
+	cp.Close()
+
+	if cp, err = openCheckpoint(path, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cp.Done(b.key()); !ok {
+		t.Errorf("Wanted: %s done", b.URL)
+	}
+	cp.Close()
+
+	// a new scan starts over
+	if cp, err = openCheckpoint(path, false); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+	if cp, err = openCheckpoint(path, true); err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if _, ok := cp.Done(a.key()); ok {
+		t.Error("Wanted an empty checkpoint")
+	}
+
+	var none *checkpoint // no -checkpoint
+	none.Target(a.key(), a.URL, nil)
+	if _, ok := none.Done(a.key()); ok || none.Close() != nil {
+		t.Error("Wanted a nil checkpoint to record nothing")
+	}
+}
+
+// a target that can't be scanned is tried again on -resume
+func TestCheckpointFailedTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	cp, err := openCheckpoint(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &hostInfo{URL: "ftp://a.example/", Method: "POST"}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	scanHost(context.Background(), smuggler.NewScanner(smuggler.Options{}), nil, cp, rec, &wg)
+	cp.Close()
+
+	if cp, err = openCheckpoint(path, true); err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if _, ok := cp.Done(rec.key()); ok {
+		t.Errorf("Wanted: %s not done", rec.URL)
+	}
+}
@@ -1,12 +1,5 @@
 package config
 
-import (
-	"net/url"
-	"sync"
-	"time"
-)
-
-type Priority uint8
 type LEVEL byte
 
 const (
@@ -15,28 +8,11 @@ const (
 	E
 )
 
-const (
-	H2CLTE Priority = iota
-	H2TECL
-	TECLH2
-	TEH2CL
-	CLTEH2
-	CLH2TE
-)
-
-type Global struct {
-	Test LEVEL
+var levelName = map[LEVEL]string{B: "B", M: "M", E: "E"}
 
-	ExitEarly  bool
-	Concurrent bool
-
-	Priority Priority
-
-	Timeout time.Duration
-	Wg      sync.WaitGroup
-	DestURL *url.URL
-
-	Hdr map[string][]string // globally available headers
+func (l LEVEL) String() string {
+	if res, ok := levelName[l]; ok {
+		return res
+	}
+	return "?"
 }
-
-var Glob Global
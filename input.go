@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"smuggler/smuggler"
+	"smuggler/smuggler/importer"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// input records: one URL per line or one JSON object per line (JSON Lines, both can be mixed),
+// or a JSON array of objects. records are read one at a time, the input is never loaded in full
+
+const maxRecordSize = 16 << 20 // a line, bodies included
+
+// overrides of the command line options for a target, unset fields keep the flag's value
+type targetOptions struct {
+	Timeout       *float64 `json:"timeout,omitempty"` // seconds
+	Test          string   `json:"test,omitempty"`    // basic, double or exhaustive
+	ExitEarly     *bool    `json:"exit_early,omitempty"`
+	Concurrent    *bool    `json:"concurrent,omitempty"`
+	Confirm       *bool    `json:"confirm,omitempty"`
+	Safe          bool     `json:"safe,omitempty"` // a target can't turn -safe off
+	MinConfidence *float64 `json:"min_confidence,omitempty"`
+}
+
+// reads the records of r, fn is called for each one in input order until it returns false.
+// invalid records are passed to bad with their line number (the index for a JSON array) and skipped
+func readTargets(r io.Reader, fn func(*hostInfo) bool, bad func(line int, err error)) error {
+	br := bufio.NewReader(r)
+	if isArray(br) {
+		decoder := json.NewDecoder(br)
+		decoder.DisallowUnknownFields() // same as the JSON Lines records
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("error getting json decoder token: %w", err)
+		}
+		for i := 1; decoder.More(); i++ {
+			var rec hostInfo
+			if err := decoder.Decode(&rec); err != nil {
+				var syntaxErr *json.SyntaxError
+				if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+					return err // the rest of the array can't be read
+				}
+				bad(i, err)
+				continue
+			}
+			if err := rec.normalize(); err != nil {
+				bad(i, err)
+				continue
+			}
+			if !fn(&rec) {
+				return nil
+			}
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		rec, err := parseRecord(scanner.Text())
+		if err != nil {
+			bad(line, err)
+			continue
+		}
+		if rec != nil && !fn(rec) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// the input is a JSON array if its first character (after spaces) is a bracket
+func isArray(br *bufio.Reader) bool {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return false
+		}
+		if !unicode.IsSpace(rune(b)) {
+			_ = br.UnreadByte()
+			return b == '['
+		}
+	}
+}
+
+// a URL or a JSON object, nil for blank lines and comments
+func parseRecord(line string) (*hostInfo, error) {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	rec := &hostInfo{URL: line}
+	if strings.HasPrefix(line, "{") {
+		rec = &hostInfo{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields() // a typo in an override must not go unnoticed
+		if err := decoder.Decode(rec); err != nil {
+			return nil, err
+		}
+	}
+	return rec, rec.normalize()
+}
+
+// checks the record and fills in the defaults of the flags
+func (rec *hostInfo) normalize() error {
+	if len(rec.URL) == 0 {
+		return errors.New("missing url")
+	}
+	if len(rec.Method) == 0 {
+		rec.Method = *method
+	}
+	rec.Method = strings.ToUpper(strings.TrimSpace(rec.Method))
+	if rec.Hdrs == nil {
+		rec.Hdrs = make(map[string][]string)
+	}
+	for _, name := range slices.Sorted(maps.Keys(rec.Cookies)) {
+		rec.Hdrs["Cookie"] = append(rec.Hdrs["Cookie"], name+"="+rec.Cookies[name])
+	}
+	rec.Cookies = nil
+
+	if len(rec.Techniques) > 0 {
+		techs, err := smuggler.ParseTechniques(strings.Join(rec.Techniques, ","))
+		if err != nil {
+			return err
+		}
+		rec.Techniques = techs
+	}
+	if o := rec.Options; o != nil {
+		if o.Timeout != nil && *o.Timeout <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		if o.MinConfidence != nil && (*o.MinConfidence < 0 || *o.MinConfidence > 1) {
+			return errors.New("min_confidence must be in range [0-1]")
+		}
+		if len(o.Test) > 0 && !contains([]string{"BASIC", "DOUBLE", "EXHAUSTIVE"}, strings.ToUpper(o.Test)) {
+			return fmt.Errorf("unknown test type: %s", o.Test)
+		}
+	}
+	return nil
+}
+
+// the scanner of the record: s unless the record overrides its options
+func (rec *hostInfo) scanner(s *smuggler.Scanner) *smuggler.Scanner {
+	if len(rec.Techniques) == 0 && rec.Options == nil {
+		return s
+	}
+	opts := s.Options()
+	if len(rec.Techniques) > 0 {
+		opts.Techniques = rec.Techniques
+	}
+	if o := rec.Options; o != nil {
+		if o.Timeout != nil {
+			opts.Timeout = time.Duration(*o.Timeout * float64(time.Second))
+		}
+		if len(o.Test) > 0 {
+			opts.Level = getLevel(strings.ToUpper(o.Test))
+		}
+		if o.ExitEarly != nil {
+			opts.ExitEarly = *o.ExitEarly
+		}
+		if o.Concurrent != nil {
+			opts.Concurrent = *o.Concurrent
+		}
+		if o.Confirm != nil {
+			opts.Confirm = *o.Confirm
+		}
+		if o.MinConfidence != nil {
+			opts.MinConfidence = *o.MinConfidence
+		}
+		opts.Safe = opts.Safe || o.Safe // Scan rejects confirm and disruptive techniques in safe mode
+	}
+	return smuggler.NewScanner(opts)
+}
+
+// the captured requests of -import, each one is scanned as it was sent
+func importTargets() []*hostInfo {
+	var res []*hostInfo
+	for _, path := range strings.Split(*imports, ",") {
+		if path = strings.TrimSpace(path); len(path) == 0 {
+			continue
+		}
+		targets, err := importer.Load(path, *importScheme)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error importing requests")
+		}
+		n := 0
+		for i, t := range targets {
+			rec := &hostInfo{URL: t.URL, Method: t.Method, Body: t.Body, Hdrs: t.Headers}
+			if err := rec.normalize(); err != nil { // same checks as the records of -i
+				log.Error().Err(err).Msgf("%s: invalid request %d", path, i+1)
+				continue
+			}
+			res = append(res, rec)
+			n++
+		}
+		log.Info().Msgf("imported %d requests from %s", n, path)
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"smuggler/config"
+	"smuggler/smuggler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadTargets(t *testing.T) {
+	table := []struct {
+		name  string
+		input string
+		urls  []string
+		bad   []int
+	}{
+		{"urls", "http://a/\n\n# comment\nhttp://b/\n", []string{"http://a/", "http://b/"}, nil},
+		{"json lines", `{"url": "http://a/", "method": "put"}` + "\nhttp://b/\n" + `{"url": "http://c/"}`,
+			[]string{"http://a/", "http://b/", "http://c/"}, nil},
+		{"invalid lines", `{"url": "http://a/"` + "\n" + `{"method": "GET"}` + "\n" + `{"url": "http://c/", "techniques": ["xx"]}` +
+			"\n" + `{"url": "http://d/", "optoins": {}}` + "\nhttp://e/", []string{"http://e/"}, []int{1, 2, 3, 4}},
+		{"array", ` [{"url": "http://a/"}, {"url": "http://b/", "options": {"test": "xx"}}, {"url": "http://c/"}]`,
+			[]string{"http://a/", "http://c/"}, []int{2}},
+		{"unknown field in array", `[{"url": "http://a/", "optoins": {}}, {"url": "http://b/", "options": {"tiemout": 3}}, {"url": "http://c/"}]`,
+			[]string{"http://c/"}, []int{1, 2}},
+		{"truncated array", `[{"url": "http://a/"}, {"url": `, []string{"http://a/"}, nil},
+	}
+	for _, Case := range table {
+		t.Run(Case.name, func(t *testing.T) {
+			var urls []string
+			var bad []int
+			readTargets(strings.NewReader(Case.input), func(rec *hostInfo) bool {
+				urls = append(urls, rec.URL)
+				return true
+			}, func(line int, err error) {
+				bad = append(bad, line)
+			})
+			if !reflect.DeepEqual(urls, Case.urls) || !reflect.DeepEqual(bad, Case.bad) {
+				t.Errorf("Wanted: %v %v, Got: %v %v", Case.urls, Case.bad, urls, bad)
+			}
+		})
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	rec, err := parseRecord(`{"url": "http://a/", "method": "put", "body": "x=1", "headers": {"Cookie": ["a=1"], "X": ["y"]},
+		"cookies": {"c": "3", "b": "2"}, "techniques": ["CLTE", "h2cl"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &hostInfo{
+		URL:        "http://a/",
+		Method:     "PUT",
+		Body:       "x=1",
+		Hdrs:       map[string][]string{"Cookie": {"a=1", "b=2", "c=3"}, "X": {"y"}},
+		Techniques: []string{"clte", "h2cl"},
+	}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("Wanted: %+v, Got: %+v", want, rec)
+	}
+
+	rec, _ = parseRecord("http://b/")
+	if rec.Method != *method || rec.Hdrs == nil {
+		t.Errorf("Wanted: the defaults of the flags, Got: %+v", rec)
+	}
+}
+
+func TestTargetScanner(t *testing.T) {
+	base := smuggler.NewScanner(smuggler.Options{Timeout: time.Second * 5, Level: config.B, Techniques: []string{"clte"}})
+
+	rec, _ := parseRecord(`{"url": "http://a/"}`)
+	if rec.scanner(base) != base {
+		t.Error("Wanted: the scanner of the flags")
+	}
+
+	rec, err := parseRecord(`{"url": "http://a/", "techniques": ["tecl"],
+		"options": {"timeout": 1.5, "test": "double", "confirm": true, "safe": true, "min_confidence": 0.5}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := rec.scanner(base).Options()
+	if opts.Timeout != time.Millisecond*1500 || opts.Level != config.M || !opts.Confirm || !opts.Safe ||
+		opts.MinConfidence != 0.5 || !reflect.DeepEqual(opts.Techniques, []string{"tecl"}) {
+		t.Errorf("Wanted: the overrides, Got: %+v", opts)
+	}
+	if base.Options().Timeout != time.Second*5 || base.Options().Techniques[0] != "clte" {
+		t.Error("Wanted: the scanner of the flags to be unchanged")
+	}
+}
+
+// imported requests get the same checks and defaults as the records of -i
+func TestImportTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "req.txt")
+	os.WriteFile(path, []byte("get /a HTTP/1.1\r\nHost: a.example\r\n\r\n"), 0644)
+	prev := *imports
+	*imports = path
+	defer func() { *imports = prev }()
+
+	recs := importTargets()
+	if len(recs) != 1 || recs[0].URL != "https://a.example/a" || recs[0].Method != "GET" || recs[0].Hdrs == nil {
+		t.Errorf("Wanted: a normalized GET of https://a.example/a, Got: %+v", recs)
+	}
+}
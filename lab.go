@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"smuggler/smuggler/lab"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// smuggler lab [options]: runs a local front-end/back-end pair to test detectors against
+func runLab(args []string) {
+	fs := flag.NewFlagSet("lab", flag.ExitOnError)
+	profile := fs.String("profile", "safe", "`name` of the chain to run: "+strings.Join(lab.ProfileNames(), ", "))
+	addr := fs.String("addr", "127.0.0.1:8080", "`address` the front-end listens on")
+	list := fs.Bool("list", false, "list available profiles and exit")
+	frontTE := fs.String("front-te", "", "override front-end Transfer-Encoding `mode`: ignore, strict, lenient")
+	backTE := fs.String("back-te", "", "override back-end Transfer-Encoding `mode`: ignore, strict, lenient")
+	frontCL0 := fs.Bool("front-cl0", false, "front-end ignores Content-Length")
+	backCL0 := fs.Bool("back-cl0", false, "back-end ignores Content-Length (CL.0)")
+	h2 := fs.Bool("h2", false, "serve HTTP/2 over TLS on the front-end")
+	passthrough := fs.Bool("passthrough", false, "h2 front-end passes CL/TE and CRLF in headers to the back-end")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: smuggler lab [options]\nFlags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *list {
+		for _, name := range lab.ProfileNames() {
+			fmt.Printf("%-8s %s\n", name, lab.Profiles[name].Desc)
+		}
+		return
+	}
+
+	p, ok := lab.Profiles[*profile]
+	if !ok {
+		log.Fatal().Msgf("unknown profile: %s", *profile)
+	}
+	var err error
+	if len(*frontTE) > 0 {
+		if p.Front.TE, err = lab.ParseTEMode(*frontTE); err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+	}
+	if len(*backTE) > 0 {
+		if p.Back.TE, err = lab.ParseTEMode(*backTE); err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+	}
+	p.Front.IgnoreCL = p.Front.IgnoreCL || *frontCL0
+	p.Back.IgnoreCL = p.Back.IgnoreCL || *backCL0
+	p.H2 = p.H2 || *h2
+	p.Passthrough = p.Passthrough || *passthrough
+
+	l, err := lab.Start(p, *addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	defer l.Close()
+
+	log.Info().
+		Str("profile", p.Name).
+		Str("front-te", p.Front.TE.String()).
+		Str("back-te", p.Back.TE.String()).
+		Bool("back-cl0", p.Back.IgnoreCL).
+		Bool("h2", p.H2).
+		Msgf("lab listening on %s", l.URL())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+}
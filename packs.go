@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"smuggler/smuggler"
+
+	"github.com/rs/zerolog/log"
+)
+
+// smuggler export-packs [options]: writes the built-in mutations as payload packs
+func runExportPacks(args []string) {
+	fs := flag.NewFlagSet("export-packs", flag.ExitOnError)
+	dir := fs.String("o", "packs", "`directory` the packs are written to")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: smuggler export-packs [options]\nFlags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := smuggler.ExportPacks(*dir); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	log.Info().Msgf("built-in mutations written to %s", *dir)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"smuggler/smuggler"
+	"smuggler/smuggler/poc"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var pocs []string // formats of -poc, none if it isn't set
+
+// comma separated formats, all of them if empty
+func pocFormats(list string) ([]string, error) {
+	if len(strings.TrimSpace(list)) == 0 {
+		return poc.Formats, nil
+	}
+	var res []string
+	for _, format := range strings.Split(list, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if !contains(poc.Formats, format) {
+			return nil, fmt.Errorf("unknown PoC format: %s: valid formats: %s", format, strings.Join(poc.Formats, ","))
+		}
+		res = append(res, format)
+	}
+	return res, nil
+}
+
+// writes the PoCs of a finding of the scan in -poc
+func exportPoC(f smuggler.Finding, formats []string) {
+	paths, err := poc.Export(*pocDir, formats, f)
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", f.Target).Msgf("error writing %s PoC", f.Technique)
+		return
+	}
+	log.Info().Str("endpoint", f.Target).Msgf("%s PoC written to %s", f.Technique, strings.Join(paths, ", "))
+}
+
+// smuggler export-poc [options]: writes the PoCs of the findings of a JSON Lines report
+func runExportPoC(args []string) {
+	fs := flag.NewFlagSet("export-poc", flag.ExitOnError)
+	input := fs.String("i", "", "JSON Lines `report` (-o findings.jsonl) to read the findings from (default: STDIN)")
+	dir := fs.String("o", "poc", "`directory` the PoCs are written to, a subdirectory per host")
+	list := fs.String("formats", "", "comma separated `list` of formats: "+strings.Join(poc.Formats, ",")+" (default: all)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: smuggler export-poc [options]\nFlags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	formats, err := pocFormats(*list)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	file := getInput(*input)
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	n := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var f smuggler.Finding
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			log.Error().Err(err).Msgf("%s: invalid finding at line %d", file.Name(), line)
+			continue
+		}
+		paths, err := poc.Export(*dir, formats, f)
+		if err != nil {
+			log.Error().Err(err).Msgf("%s: finding at line %d", file.Name(), line)
+			continue
+		}
+		n += len(paths)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal().Err(err).Msgf("error reading %s", file.Name())
+	}
+	log.Info().Msgf("%d PoC files written to %s", n, *dir)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"smuggler/smuggler"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// smuggler replay [options] <finding-file>: re-tests the findings of a JSON Lines report (or a
+// single finding) with the requests they were found with
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	out := fs.String("o", "", "`file` to write the results to (JSON Lines)")
+	nth := fs.Int("n", 0, "only replay the `nth` finding of the file (default: all)")
+	wait := fs.Uint("T", 5, "per-request `timeout` in seconds of findings that don't record the timeouts of their scan")
+	for _, name := range []string{"v", "proxy", "resolve", "dns", "sslkeylog", "sni", "tls-min", "tls-max", "ciphers", "alpn", "cert", "key"} {
+		f := flag.Lookup(name) // same variables as the scan flags
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: smuggler replay [options] <finding-file>\n"+
+			"The exit status is 2 if a finding still reproduces.\nFlags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	opts := smuggler.Options{Timeout: time.Duration(*wait) * time.Second}
+	var err error
+	if opts.TLS, err = tlsOptions(); err != nil {
+		log.Fatal().Err(err).Msg("invalid TLS options")
+	}
+	if opts.Dialer, err = connDialer(); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	defer file.Close()
+
+	var enc *json.Encoder
+	if len(*out) > 0 {
+		w, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		defer w.Close()
+		enc = json.NewEncoder(w)
+	}
+
+	reproduced := 0
+	err = readFindings(file, func(i int, f smuggler.Finding) {
+		if *nth > 0 && i != *nth {
+			return
+		}
+		log.Info().Str("endpoint", f.Target).Msgf("replaying %s finding %d", f.Technique, i)
+		res, err := smuggler.Replay(context.Background(), f, opts)
+		if err != nil {
+			log.Error().Err(err).Str("endpoint", f.Target).Msgf("finding %d can't be replayed", i)
+			return
+		}
+		state := "not reproduced"
+		if res.Reproduced {
+			state = "REPRODUCED"
+			reproduced++
+		}
+		fmt.Printf("%d\t%s\t%s %s\t%s: %s\n", i, state, f.Technique, f.Mutation, f.Target, res.Reason)
+		if enc != nil {
+			if err := enc.Encode(res); err != nil {
+				log.Error().Err(err).Msg("error writing result")
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msgf("error reading %s", file.Name())
+	}
+	if reproduced > 0 {
+		os.Exit(2)
+	}
+}
+
+// calls fn with each finding of r and its index (from 1): JSON Lines or concatenated objects
+func readFindings(r io.Reader, fn func(int, smuggler.Finding)) error {
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var f smuggler.Finding
+		if err := dec.Decode(&f); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("finding %d: %w", i, err)
+		}
+		fn(i, f)
+	}
+}
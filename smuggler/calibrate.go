@@ -0,0 +1,133 @@
+package smuggler
+
+import (
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// per-target timing: benign requests measure the latency of the target before the detectors run,
+// the probe timeout and the "disconnected early" threshold are derived from it instead of -T. a
+// slow host gets a timeout well above its worst latency (no timing hits from slow responses), a
+// fast one doesn't wait -T seconds for every probe that hangs
+
+const (
+	calibrationSamples = 8
+	minTimeout         = time.Second      // jitter, the first request of a connection pool...
+	maxTimeout         = time.Second * 30 // a host this slow can't be tested with timing
+	sigmas             = 6                // a normal response is (almost) never this far above the mean
+)
+
+type calibration struct {
+	Mean   time.Duration
+	StdDev time.Duration
+	Max    time.Duration
+
+	Timeout time.Duration // a probe without a response after it is a timeout
+	Early   time.Duration // an empty response before it is a disconnect, after it a timeout
+}
+
+// derives the thresholds from the latency of normal responses, nil without enough samples
+func newCalibration(samples []time.Duration) *calibration {
+	if len(samples) < calibrationSamples/2 {
+		return nil
+	}
+	var sum, max float64
+	for _, s := range samples {
+		sum += float64(s)
+		max = math.Max(max, float64(s))
+	}
+	mean := sum / float64(len(samples))
+	var sq float64
+	for _, s := range samples {
+		sq += (float64(s) - mean) * (float64(s) - mean)
+	}
+	sd := math.Sqrt(sq / float64(len(samples)-1))
+
+	// a handful of samples underestimates the spread, it's at least a tenth of the mean and the
+	// timeout is at least 3 times the slowest response
+	timeout := math.Max(mean+sigmas*math.Max(sd, mean/10), 3*max)
+	timeout = math.Min(math.Max(timeout, float64(minTimeout)), float64(maxTimeout))
+	return &calibration{
+		Mean:    time.Duration(mean),
+		StdDev:  time.Duration(sd),
+		Max:     time.Duration(max),
+		Timeout: time.Duration(timeout),
+		Early:   time.Duration(timeout * 4 / 5), // -T minus a second with the default -T
+	}
+}
+
+// measures the latency of the protocols the target supports
+func (d *DesyncerImpl) calibrate() {
+	if d.Opts.NoCalibrate {
+		return
+	}
+	if d.H1Supported {
+		d.h1cal = d.measure(false)
+	}
+	if d.H2Supported {
+		d.h2cal = d.measure(true)
+	}
+}
+
+func (d *DesyncerImpl) measure(isH2 bool) *calibration {
+	var samples []time.Duration
+	for i := 0; i < calibrationSamples; i++ {
+		ret, err := d.benign(isH2)
+		if err != nil || ret.Code != ProbeNormal {
+			break // unstable (or the protocol isn't supported), enough samples may have been taken
+		}
+		samples = append(samples, ret.Duration)
+	}
+
+	proto := "HTTP/1.1"
+	if isH2 {
+		proto = "h2"
+	}
+	c := newCalibration(samples)
+	if c == nil {
+		log.Debug().Str("endpoint", d.URL.String()).Str("protocol", proto).
+			Msgf("calibration failed (%d/%d normal responses), using a timeout of %v", len(samples), calibrationSamples, d.Opts.Timeout)
+		return nil
+	}
+	log.Debug().Str("endpoint", d.URL.String()).Str("protocol", proto).
+		Dur("mean", c.Mean).Dur("stddev", c.StdDev).Dur("max", c.Max).
+		Msgf("calibrated timeout: %v", c.Timeout)
+	return c
+}
+
+// a GET of the target
+func (d *DesyncerImpl) benign(isH2 bool) (*Probe, error) {
+	if isH2 {
+		return (&H2{d}).get(d.URL.Path)
+	}
+	return d.H1Test(d.victimPl())
+}
+
+// probe timeout and disconnect threshold of a protocol, from -T if it wasn't calibrated
+func (d *DesyncerImpl) timeouts(isH2 bool) (timeout, early time.Duration) {
+	c := d.h1cal
+	if isH2 {
+		c = d.h2cal
+	}
+	if c == nil {
+		return d.Opts.Timeout, d.Opts.Timeout - time.Second
+	}
+	return c.Timeout, c.Early
+}
+
+// a timing hit only counts if benign requests still get a timely response, otherwise the host
+// is just slow (or down) right now
+func (d *DesyncerImpl) recheck(isH2 bool) bool {
+	_, early := d.timeouts(isH2)
+	for i := 0; i < 2; i++ {
+		ret, err := d.benign(isH2)
+		if err != nil || ret.Code != ProbeNormal || ret.Duration >= early {
+			log.Debug().Err(err).Str("endpoint", d.URL.String()).
+				Msg("baseline request is slow or failed, discarding the timing hit")
+			return false
+		}
+	}
+	return true
+}
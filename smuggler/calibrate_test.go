@@ -0,0 +1,89 @@
+package smuggler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"smuggler/smuggler/h1"
+	"testing"
+	"time"
+)
+
+func ms(n ...int) []time.Duration {
+	res := make([]time.Duration, len(n))
+	for i, v := range n {
+		res[i] = time.Duration(v) * time.Millisecond
+	}
+	return res
+}
+
+func TestNewCalibration(t *testing.T) {
+	table := []struct {
+		name    string
+		samples []time.Duration
+		timeout time.Duration // zero if not calibrated
+	}{
+		{"too few samples", ms(10, 10, 10), 0},
+		{"fast host", ms(10, 12, 11, 9, 10, 10, 11, 12), minTimeout},
+		{"slow host", ms(2000, 2100, 1900, 2500, 2000, 2050, 1950, 2000), time.Millisecond * 7500},
+		{"outlier", ms(500, 500, 500, 500, 500, 500, 500, 4000), time.Second * 12},
+		{"very slow host", ms(12000, 12000, 12000, 12000), maxTimeout},
+	}
+	for _, Case := range table {
+		t.Run(Case.name, func(t *testing.T) {
+			c := newCalibration(Case.samples)
+			if Case.timeout == 0 {
+				if c != nil {
+					t.Errorf("Wanted: no calibration, Got: %+v", c)
+				}
+				return
+			}
+			if c == nil {
+				t.Fatal("Wanted: a calibration, Got: nil")
+			}
+			if c.Timeout != Case.timeout {
+				t.Errorf("Wanted: %v, Got: %v", Case.timeout, c.Timeout)
+			}
+			if c.Early >= c.Timeout || c.Early < c.Max && c.Timeout != maxTimeout {
+				t.Errorf("disconnect threshold %v not between %v and %v", c.Early, c.Max, c.Timeout)
+			}
+		})
+	}
+}
+
+// a response without a body is a disconnection before the early threshold, a timeout after it
+func TestProbeNoBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.Header().Set("Content-Length", "0")
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	d := &DesyncerImpl{Method: "GET", Hdr: make(map[string][]string), Opts: &Options{Timeout: time.Second * 2}, Ctx: context.Background()}
+	if err := d.ParseURL(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		path  string
+		early time.Duration
+		want  int
+	}{
+		{"/", time.Second, ProbeNormal},
+		{"/empty", time.Second, ProbeDisconnected},
+		{"/empty", 0, ProbeTimeout},
+	}
+	for _, Case := range table {
+		d.h1cal = &calibration{Timeout: time.Second * 2, Early: Case.early}
+		ret, err := d.H1Test(&h1.Payload{Method: "GET", URL: url.URL{Path: Case.path}, Header: h1.Header{{Name: "Host", Value: d.URL.Host}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.Code != Case.want {
+			t.Errorf("%s (early %v): Wanted: %v, Got: %v", Case.path, Case.early, Case.want, ret.Code)
+		}
+	}
+}
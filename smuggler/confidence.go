@@ -0,0 +1,94 @@
+package smuggler
+
+import (
+	"math"
+	"smuggler/smuggler/h1"
+)
+
+// confidence of timing hits: each piece of evidence is a likelihood ratio, how much more likely
+// it is on a desync than on a false positive (slow host, rate limiting, flaky network...). the
+// prior odds are multiplied by the ratio of each signal (naive Bayes, the signals are taken as
+// independent), three timeout/normal pairs and nothing else give the 0.75 of the old pair count
+
+const (
+	priorOdds = 1.0 / 9 // 1 in 10 timeouts is a desync
+
+	pairRatio       = 3    // a timeout/normal pair in a row
+	controlRatio    = 3    // the request without the mutated header gets a normal response
+	controlTimeout  = 0.1  // ...times out as well, the header isn't what makes the difference
+	stableRatio     = 2    // the latency of the target barely varies
+	jitteryRatio    = 0.5  // ...varies as much as it is
+	freshConnRatio  = 2    // the hit is seen on a new connection
+	freshConnMissed = 0.25 // ...isn't, it depends on the state of the connection
+)
+
+// a piece of evidence for (Ratio > 1) or against (Ratio < 1) a desync
+type Signal struct {
+	Name  string  `json:"name"`
+	Ratio float64 `json:"ratio"` // likelihood ratio
+}
+
+func confidence(signals []Signal) float64 {
+	odds := priorOdds
+	for _, s := range signals {
+		odds *= s.Ratio
+	}
+	return odds / (1 + odds)
+}
+
+func pairsSignal(pairs int) Signal {
+	return Signal{Name: "timeout/normal pairs", Ratio: math.Pow(pairRatio, float64(pairs))}
+}
+
+// the request without the mutated header, nil if it couldn't be sent
+func controlSignal(ret *Probe) Signal {
+	s := Signal{Name: "control request", Ratio: 1}
+	switch {
+	case ret == nil:
+	case ret.Code == ProbeNormal:
+		s.Ratio = controlRatio
+	case ret.Code == ProbeTimeout:
+		s.Ratio = controlTimeout
+	}
+	return s
+}
+
+// spread of the latency measured by the calibration, neutral if the target wasn't calibrated
+func (d *DesyncerImpl) baselineSignal(isH2 bool) Signal {
+	c := d.h1cal
+	if isH2 {
+		c = d.h2cal
+	}
+	s := Signal{Name: "baseline latency", Ratio: 1}
+	if c == nil || c.Mean <= 0 {
+		return s
+	}
+	switch cv := float64(c.StdDev) / float64(c.Mean); {
+	case cv < 0.5:
+		s.Ratio = stableRatio
+	case cv >= 1:
+		s.Ratio = jitteryRatio
+	}
+	return s
+}
+
+func freshConnSignal(seen bool) Signal {
+	if seen {
+		return Signal{Name: "new connection", Ratio: freshConnRatio}
+	}
+	return Signal{Name: "new connection", Ratio: freshConnMissed}
+}
+
+// signals of a timing hit: timeout/normal pairs in a row, the control probe (nil if it couldn't
+// be sent) and whether the hit was seen on a new connection
+func (d *DesyncerImpl) timingSignals(isH2 bool, pairs int, control *Probe, fresh bool) []Signal {
+	return []Signal{pairsSignal(pairs), d.baselineSignal(isH2), controlSignal(control), freshConnSignal(fresh)}
+}
+
+// sends the timing probe p without the mutated header
+func (d *DesyncerImpl) h1Control(p *h1.Payload) *Probe {
+	c := *p
+	c.HdrPl = ""
+	ret, _ := d.H1Test(&c)
+	return ret
+}
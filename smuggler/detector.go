@@ -0,0 +1,168 @@
+package smuggler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"smuggler/smuggler/tests"
+	"strings"
+	"sync"
+)
+
+// a technique that can be run against a target
+type Detector interface {
+	Name() string
+	Supported(d *DesyncerImpl) bool // prerequisites, e.g. the target speaks h1 or h2
+	// runs the technique, ctx is the scan context. returns the findings of the run
+	Run(ctx context.Context, d *DesyncerImpl) []Finding
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Detector)
+	defaults   []string // registration order, used when no technique is selected
+)
+
+// adds a detector, the name is what is passed to --techniques
+func Register(det Detector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name := strings.ToLower(det.Name())
+	if _, ok := registry[name]; ok {
+		panic("smuggler: detector registered twice: " + name)
+	}
+	registry[name] = det
+	defaults = append(defaults, name)
+}
+
+// names of the registered detectors, in the default order
+func Detectors() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return slices.Clone(defaults)
+}
+
+// parses a comma separated list of detector names, the order is kept
+func ParseTechniques(s string) ([]string, error) {
+	var res []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); len(name) == 0 {
+			continue
+		}
+		if _, err := lookupDetectors([]string{name}); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(res, name) {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// implemented by detectors that know whether their probes can leave a smuggled prefix in a
+// back-end queue, detectors that don't implement it are taken as disruptive
+type SafetyAware interface {
+	Disruptive() bool
+}
+
+func isDisruptive(det Detector) bool {
+	if s, ok := det.(SafetyAware); ok {
+		return s.Disruptive()
+	}
+	return true
+}
+
+// the detectors that can be run in safe mode, selecting a disruptive one is an error
+func safeDetectors(dets []Detector, selected bool) ([]Detector, error) {
+	var res []Detector
+	for _, det := range dets {
+		if !isDisruptive(det) {
+			res = append(res, det)
+		} else if selected {
+			return nil, fmt.Errorf("technique %s can poison other users' requests, it can't be run in safe mode", det.Name())
+		}
+	}
+	return res, nil
+}
+
+// checks that the techniques (all registered ones if names is empty) can be run in safe mode
+func CheckSafe(names []string) error {
+	dets, err := lookupDetectors(names)
+	if err != nil {
+		return err
+	}
+	_, err = safeDetectors(dets, len(names) > 0)
+	return err
+}
+
+// all registered detectors if names is empty
+func lookupDetectors(names []string) ([]Detector, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	if len(names) == 0 {
+		names = defaults
+	}
+	res := make([]Detector, 0, len(names))
+	for _, name := range names {
+		det, ok := registry[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown technique: %s: valid techniques: %s", name, strings.Join(defaults, ","))
+		}
+		res = append(res, det)
+	}
+	return res, nil
+}
+
+// built-in detector, run reports success and the findings are the ones recorded
+// with the detector's techniques
+type detector struct {
+	name       string
+	h1, h2     bool // required protocol support
+	techniques []Technique
+	run        func(d *DesyncerImpl) bool
+}
+
+func (det *detector) Name() string {
+	return det.name
+}
+
+func (det *detector) Disruptive() bool {
+	return slices.ContainsFunc(det.techniques, Technique.Disruptive)
+}
+
+func (det *detector) Supported(d *DesyncerImpl) bool {
+	return (!det.h1 || d.H1Supported) && (!det.h2 || d.H2Supported)
+}
+
+func (det *detector) Run(ctx context.Context, d *DesyncerImpl) []Finding {
+	if ctx.Err() != nil {
+		return nil
+	}
+	det.run(d)
+
+	var res []Finding
+	for _, f := range d.Findings() {
+		if slices.Contains(det.techniques, f.Technique) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func init() {
+	for _, det := range []*detector{
+		{"cl0", true, false, []Technique{CL0}, func(d *DesyncerImpl) bool { return (&CL{d}).runCL0() }},
+		{"clte", true, false, []Technique{CLTE}, func(d *DesyncerImpl) bool { return (&CL{d}).runCLTE() }},
+		{"tete", true, false, []Technique{TETE}, func(d *DesyncerImpl) bool { return (&TE{d}).runTETE() }},
+		{"tecl", true, false, []Technique{TECL}, func(d *DesyncerImpl) bool { return (&TE{d}).runTECL() }},
+		{"h2cl", false, true, []Technique{H2CL}, func(d *DesyncerImpl) bool { return (&H2{d}).run(tests.CL) }},
+		{"h2te", false, true, []Technique{H2TE}, func(d *DesyncerImpl) bool { return (&H2{d}).run(tests.TE) }},
+		{"h2crlf", false, true, []Technique{H2CRLF}, func(d *DesyncerImpl) bool { return (&H2{d}).run(tests.CRLF) }},
+		{"h20", false, true, []Technique{H20}, func(d *DesyncerImpl) bool { return (&H2{d}).runH20() }},
+		{"tunnel", false, true, []Technique{H2Tunnel}, func(d *DesyncerImpl) bool { return (&Tunnel{DesyncerImpl: d}).Run() }},
+	} {
+		Register(det)
+	}
+}
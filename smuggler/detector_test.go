@@ -0,0 +1,68 @@
+package smuggler_test
+
+import (
+	"context"
+	"slices"
+	"smuggler/smuggler"
+	"testing"
+)
+
+type nopDetector struct{}
+
+func (nopDetector) Name() string                                                   { return "CLTE" }
+func (nopDetector) Supported(*smuggler.DesyncerImpl) bool                          { return true }
+func (nopDetector) Run(context.Context, *smuggler.DesyncerImpl) []smuggler.Finding { return nil }
+
+func TestDetectors(t *testing.T) {
+	want := []string{"cl0", "clte", "tete", "tecl", "h2cl", "h2te", "h2crlf", "h20", "tunnel"}
+	if got := smuggler.Detectors(); !slices.Equal(got, want) {
+		t.Errorf("Wanted: %v, Got: %v", want, got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Wanted a panic for a duplicate name")
+		}
+	}()
+	smuggler.Register(nopDetector{})
+}
+
+func TestParseTechniques(t *testing.T) {
+	table := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"", nil, false},
+		{"tunnel, H2TE,clte", []string{"tunnel", "h2te", "clte"}, false},
+		{"clte,tecl,clte,", []string{"clte", "tecl"}, false},
+		{"clte,cl.te", nil, true},
+	}
+	for _, Case := range table {
+		t.Run(Case.in, func(t *testing.T) {
+			got, err := smuggler.ParseTechniques(Case.in)
+			if (err != nil) != Case.err || !slices.Equal(got, Case.want) {
+				t.Errorf("Wanted: %v (error: %v), Got: %v (%v)", Case.want, Case.err, got, err)
+			}
+		})
+	}
+}
+
+func TestCheckSafe(t *testing.T) {
+	table := []struct {
+		names []string
+		err   bool
+	}{
+		{nil, false}, // disruptive defaults are skipped
+		{[]string{"clte", "tete", "h2cl", "h2te", "h2crlf"}, false},
+		{[]string{"clte", "tecl"}, true},
+		{[]string{"cl0"}, true},
+		{[]string{"h20"}, true},
+		{[]string{"tunnel"}, true},
+	}
+	for _, Case := range table {
+		if err := smuggler.CheckSafe(Case.names); (err != nil) != Case.err {
+			t.Errorf("%v: Wanted error: %v, Got: %v", Case.names, Case.err, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package dialer opens the connections of every transport, directly or through an upstream
+// proxy. proxies are only used as tunnels (HTTP CONNECT or SOCKS5), the bytes of a payload
+// reach the target as they were written.
+package dialer
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+type Dialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// dials addr directly
+var Direct Dialer = &net.Dialer{}
+
+// parses a proxy URL: http://[user:pass@]host:port (CONNECT) or socks5://[user:pass@]host:port,
+// the host name of the target is resolved by the proxy
+func FromURL(raw string) (Dialer, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid proxy URL: %s: missing host", raw)
+	}
+
+	switch u.Scheme {
+	case "http":
+		p := &connectProxy{addr: u.Host}
+		if len(u.Port()) == 0 {
+			p.addr = net.JoinHostPort(u.Hostname(), "8080")
+		}
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass))
+		}
+		return p, nil
+	case "socks5", "socks5h":
+		addr := u.Host
+		if len(u.Port()) == 0 {
+			addr = net.JoinHostPort(u.Hostname(), "1080")
+		}
+		var auth *proxy.Auth
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: pass}
+		}
+		d, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{})
+		if err != nil {
+			return nil, err
+		}
+		return d.(proxy.ContextDialer), nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme: %s: valid schemes: http,socks5", u.Scheme)
+}
+
+// dials addr with d (Direct if nil), the dial and the handshake must end before timeout. the
+// connection is wrapped with TLS if cfg isn't nil, the server name is the host of addr if unset
+func Dial(d Dialer, addr string, cfg *tls.Config, timeout time.Duration) (net.Conn, error) {
+	if d == nil {
+		d = Direct
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil || cfg == nil {
+		return conn, err
+	}
+	if len(cfg.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// an HTTP proxy used with CONNECT
+type connectProxy struct {
+	addr string
+	auth string // Proxy-Authorization
+}
+
+func (p *connectProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := Direct.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if len(p.auth) > 0 {
+		req += "Proxy-Authorization: " + p.auth + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy: CONNECT %s: %w", addr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return nil, errors.New("proxy: authentication required")
+		}
+		return nil, fmt.Errorf("proxy: CONNECT %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 { // the target spoke first
+		return &bufConn{Conn: conn, br: br}, nil
+	}
+	return conn, nil
+}
+
+type bufConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
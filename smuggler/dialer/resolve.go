@@ -0,0 +1,106 @@
+package dialer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// connect-to overrides and custom DNS: only the address that is dialed changes, the target keeps
+// its host name (Host header, SNI). an overridden address is dialed through Forward as is, a
+// proxy connects to it instead of the host
+type Resolver struct {
+	Forward Dialer            // nil to dial directly
+	Hosts   map[string]string // host:port -> address dialed instead (host:port)
+	DNS     string            // DNS server (host[:port]) the other hosts are resolved with, the system's (or the proxy's) if empty
+
+	mu     sync.Mutex
+	dialed map[string]string
+}
+
+// parses an override like curl's --resolve: host:port:addr[:port], IPv6 addresses are bracketed
+func (r *Resolver) Add(spec string) error {
+	host, rest, ok1 := strings.Cut(spec, ":")
+	if strings.HasPrefix(spec, "[") { // [::1]:443:addr
+		var i int
+		if i = strings.Index(spec, "]:"); i < 0 {
+			return fmt.Errorf("invalid resolve entry: %s: want host:port:addr", spec)
+		}
+		host, rest, ok1 = spec[1:i], spec[i+2:], true
+	}
+	port, addr, ok2 := strings.Cut(rest, ":")
+	if !ok1 || !ok2 || len(host) == 0 || len(port) == 0 || len(addr) == 0 {
+		return fmt.Errorf("invalid resolve entry: %s: want host:port:addr", spec)
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil { // no port, the target's is kept
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	if r.Hosts == nil {
+		r.Hosts = make(map[string]string)
+	}
+	r.Hosts[strings.ToLower(net.JoinHostPort(host, port))] = addr
+	return nil
+}
+
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	target, err := r.resolve(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if target != addr {
+		r.mu.Lock()
+		if r.dialed == nil {
+			r.dialed = make(map[string]string)
+		}
+		r.dialed[addr] = target
+		r.mu.Unlock()
+	}
+
+	d := r.Forward
+	if d == nil {
+		d = Direct
+	}
+	return d.DialContext(ctx, network, target)
+}
+
+// the address last dialed for addr (host:port), empty if it wasn't redirected
+func (r *Resolver) Dialed(addr string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dialed[addr]
+}
+
+func (r *Resolver) resolve(ctx context.Context, addr string) (string, error) {
+	if target, ok := r.Hosts[strings.ToLower(addr)]; ok {
+		return target, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || len(r.DNS) == 0 || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	server := r.DNS
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	res := &net.Resolver{
+		PreferGo: true, // the Go resolver is the only one that can be pointed at a server
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	ips, err := res.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips { // IPv4 first, like the system resolver
+		if ip.To4() != nil {
+			return net.JoinHostPort(ip.String(), port), nil
+		}
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
@@ -0,0 +1,124 @@
+package dialer_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/h2"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolverAdd(t *testing.T) {
+	tests := []struct {
+		spec string
+		key  string
+		addr string
+		err  bool
+	}{
+		{"example.com:443:10.0.0.1", "example.com:443", "10.0.0.1:443", false},
+		{"Example.com:80:10.0.0.1:8080", "example.com:80", "10.0.0.1:8080", false},
+		{"example.com:443:[::1]", "example.com:443", "[::1]:443", false},
+		{"example.com:443:::1", "example.com:443", "[::1]:443", false},
+		{"[::1]:443:10.0.0.1", "[::1]:443", "10.0.0.1:443", false},
+		{"example.com:443:origin.internal", "example.com:443", "origin.internal:443", false},
+		{"example.com:443", "", "", true},
+		{"example.com::10.0.0.1", "", "", true},
+		{"[::1:443:10.0.0.1", "", "", true},
+	}
+	for _, tt := range tests {
+		r := &dialer.Resolver{}
+		err := r.Add(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("Add(%q): err = %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if !tt.err && r.Hosts[tt.key] != tt.addr {
+			t.Errorf("Add(%q): Hosts = %v, want %s -> %s", tt.spec, r.Hosts, tt.key, tt.addr)
+		}
+	}
+}
+
+// answers A queries for every name with ip, other queries get no answer
+func startDNS(t *testing.T, ip [4]byte) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			msg.Header.Response = true
+			msg.Header.Authoritative = true
+			q := msg.Questions[0]
+			if q.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+					Body:   &dnsmessage.AResource{A: ip},
+				}}
+			}
+			if b, err := msg.Pack(); err == nil {
+				pc.WriteTo(b, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestResolver(t *testing.T) {
+	u := startLab(t, "h2safe")
+
+	tests := []struct {
+		name string
+		r    *dialer.Resolver
+	}{
+		{"override", &dialer.Resolver{Hosts: map[string]string{"smuggler.invalid:" + u.Port(): u.Host}}},
+		{"dns", &dialer.Resolver{DNS: startDNS(t, [4]byte{127, 0, 0, 1})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &url.URL{Scheme: "https", Host: "smuggler.invalid:" + u.Port(), Path: "/"}
+			c, err := h2.Transport{Timeout: time.Second * 2, Dialer: tt.r}.Dial(target, h2.H2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			resp, err := c.Do(&h2.Request{Method: http.MethodGet, URL: target})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want 200", resp.StatusCode)
+			}
+			if got := tt.r.Dialed(target.Host); got != u.Host {
+				t.Errorf("Dialed(%s) = %q, want %s", target.Host, got, u.Host)
+			}
+		})
+	}
+
+	// hosts that aren't overridden are dialed as is
+	r := &dialer.Resolver{Hosts: map[string]string{"other.invalid:443": u.Host}}
+	conn, err := r.DialContext(context.Background(), "tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := r.Dialed(u.Host); got != "" {
+		t.Errorf("Dialed(%s) = %q, want empty", u.Host, got)
+	}
+}
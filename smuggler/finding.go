@@ -0,0 +1,173 @@
+package smuggler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"smuggler/smuggler/dialer"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Technique string
+
+const (
+	CLTE     Technique = "CL.TE"
+	TECL     Technique = "TE.CL"
+	TETE     Technique = "TE.TE"
+	CL0      Technique = "CL.0"
+	H2CL     Technique = "H2.CL"
+	H2TE     Technique = "H2.TE"
+	H2CRLF   Technique = "H2-CRLF"
+	H20      Technique = "H2.0"
+	H2Tunnel Technique = "H2-Tunnel"
+)
+
+var techniqueDesc = map[Technique]string{
+	CLTE:   "Front-end uses Content-Length, back-end uses Transfer-Encoding",
+	TECL:   "Front-end uses Transfer-Encoding, back-end uses Content-Length",
+	TETE:   "Both support Transfer-Encoding, one of them can be made to ignore an obfuscated header",
+	CL0:    "Back-end ignores the Content-Length of requests to the endpoint",
+	H2CL:   "HTTP/2 front-end forwards an injected content-length when downgrading",
+	H2TE:   "HTTP/2 front-end forwards an injected transfer-encoding when downgrading",
+	H2CRLF: "HTTP/2 front-end forwards CRLF sequences in header fields when downgrading",
+	H20:    "Back-end ignores the body of downgraded HTTP/2 requests to the endpoint",
+	H2Tunnel: "HTTP/2 front-end forwards CRLF sequences when downgrading, a request can be tunnelled " +
+		"(the front-end doesn't reuse the back-end connection)",
+}
+
+func (t Technique) Description() string {
+	if res, ok := techniqueDesc[t]; ok {
+		return res
+	}
+	return string(t)
+}
+
+// techniques whose probes smuggle a request prefix, another user's request on the poisoned
+// back-end connection gets its response. the TE.CL timing probe leaves a byte in the queue of a
+// CL.TE chain, the normal H2.CL probe leaves at most a LF (skipped before a request line)
+var disruptiveTechniques = map[Technique]bool{TECL: true, CL0: true, H20: true, H2Tunnel: true}
+
+// unknown techniques (added by other detectors) are taken as disruptive
+func (t Technique) Disruptive() bool {
+	if _, ok := techniqueDesc[t]; !ok {
+		return true
+	}
+	return disruptiveTechniques[t]
+}
+
+// probe result codes
+const (
+	ProbeError        = -1
+	ProbeNormal       = 0
+	ProbeTimeout      = 1
+	ProbeDisconnected = 2 // disconnected before timeout
+)
+
+var outcomeName = map[int]string{
+	ProbeError:        "error",
+	ProbeNormal:       "normal",
+	ProbeTimeout:      "timeout",
+	ProbeDisconnected: "disconnected",
+}
+
+// a single request sent while testing and what came back
+type Probe struct {
+	Code     int           `json:"-"`
+	Outcome  string        `json:"outcome"`
+	Request  string        `json:"request"`
+	Duration time.Duration `json:"duration"`
+	Status   int           `json:"status,omitempty"`
+	Response string        `json:"response,omitempty"` // status line and headers
+
+	H2 *PoCRequest `json:"h2,omitempty"` // the HTTP/2 request as it was sent, Request is only a summary
+}
+
+func newProbe(code int, req string, dur time.Duration, status int) *Probe {
+	return &Probe{Code: code, Outcome: outcomeName[code], Request: req, Duration: dur, Status: status}
+}
+
+// an issue detected on a target
+type Finding struct {
+	Target     string    `json:"target"`
+	Technique  Technique `json:"technique"`
+	Header     string    `json:"header"`             // the mutated header
+	Mutation   string    `json:"mutation,omitempty"` // ID of the mutation, see tests.Mutation
+	Probes     []Probe   `json:"probes"`             // attack probe followed by the control probe
+	Confidence float64   `json:"confidence"`         // [0-1]
+	Request    string    `json:"request"`            // PoC request
+	Time       time.Time `json:"time"`
+
+	Confirmed bool    `json:"confirmed,omitempty"` // a victim request got the response of the smuggled prefix
+	Evidence  []Probe `json:"evidence,omitempty"`  // attack probe followed by the victim probe
+
+	ConnectTo string `json:"connect_to,omitempty"` // address the target was dialed at (-resolve or custom DNS)
+
+	Signals  []Signal `json:"signals,omitempty"`  // evidence the confidence of a timing hit is derived from
+	Controls []Probe  `json:"controls,omitempty"` // probes without the mutated header
+
+	Disruptive bool `json:"disruptive"` // found with probes that could have poisoned other users' requests
+
+	PoC []PoCRequest `json:"poc,omitempty"` // attack requests followed by the victim requests, as they are sent
+
+	Timeout time.Duration `json:"timeout,omitempty"` // probe timeout of the scan (calibrated or -T), used by replays
+	Early   time.Duration `json:"early,omitempty"`   // an empty response before it was a disconnect
+}
+
+// records f unless its confidence is under the reporting threshold (confirmed findings are
+// always recorded)
+func (d *DesyncerImpl) addFinding(f Finding) bool {
+	if !f.Confirmed && f.Confidence < d.Opts.MinConfidence {
+		log.Debug().
+			Str("endpoint", d.URL.String()).
+			Str("mutation", f.Mutation).
+			Msgf("%s finding discarded: confidence %.2f < %.2f", f.Technique, f.Confidence, d.Opts.MinConfidence)
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f.Target = d.URL.String()
+	f.Time = time.Now()
+	f.Disruptive = f.Technique.Disruptive() || f.Confirmed || len(f.Evidence) > 0 // confirmations smuggle a request
+	f.Timeout, f.Early = d.timeouts(len(f.Probes) > 0 && f.Probes[0].H2 != nil)
+	if r, ok := d.Opts.Dialer.(*dialer.Resolver); ok {
+		f.ConnectTo = r.Dialed(targetAddr(d.URL))
+	}
+	d.findings = append(d.findings, f)
+	return true
+}
+
+// host:port the transports dial for u
+func targetAddr(u *url.URL) string {
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (d *DesyncerImpl) Findings() []Finding {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Finding{}, d.findings...)
+}
+
+func dumpResponse(resp *http.Response) string {
+	b, err := httputil.DumpResponse(resp, false)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// each probe pair that had the expected timeout/normal outcome raises the confidence
+func pairConfidence(pairs int) float64 {
+	return 1 - 1/float64(pairs+1)
+}
@@ -5,10 +5,11 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
-	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/tlsconf"
 
 	"time"
 
@@ -31,7 +32,10 @@ type clientConn struct {
 	req *Request
 }
 
-type Transport struct{}
+type Transport struct {
+	TLS    *tlsconf.Options // nil for the defaults
+	Dialer dialer.Dialer    // nil to dial the target directly
+}
 
 func (t *Transport) RoundTrip(req *Request) (*http.Response, error) {
 	cc := clientConn{}
@@ -49,21 +53,13 @@ func (t *Transport) RoundTrip(req *Request) (*http.Response, error) {
 		}
 	}
 
-	dialer := net.Dialer{Timeout: time.Millisecond * 2000}
+	var cfg *tls.Config
 	if req.Url.Scheme == "https" {
-		cfg := &tls.Config{InsecureSkipVerify: true,
-			NextProtos: []string{"http/1.1"}}
-		conn, err := tls.DialWithDialer(&dialer, "tcp", fmt.Sprintf("%s:%s", host, port), cfg)
-		if err != nil {
-			return nil, err
-		}
-		cc.conn = conn
-	} else {
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Second*2)
-		if err != nil {
-			return nil, err
-		}
-		cc.conn = conn
+		cfg = t.TLS.Config("http/1.1")
+	}
+	cc.conn, err = dialer.Dial(t.Dialer, net.JoinHostPort(host, port), cfg, time.Second*2)
+	if err != nil {
+		return nil, err
 	}
 	defer cc.conn.Close()
 
@@ -21,7 +21,7 @@ type test struct {
 	scheme  string
 	path    string
 	body    string
-	hdrs    map[string]string
+	hdrs    h1.Header
 	timeout time.Duration
 
 	want any
@@ -35,21 +35,15 @@ func buildReqLine(_test *test, method string) *h1.Request {
 		payload.Cl = len(payload.Body)
 	}
 
-	if _test.hdrs != nil {
-		headers := make(map[string]string)
-		for k, v := range _test.hdrs {
-			headers[k] = v
-		}
-		payload.Header = headers
-	}
+	payload.Header = _test.hdrs.Clone()
 	return &h1.Request{Payload: &payload, Url: &url, Timeout: _test.timeout}
 }
 
-func buildReqHdr(lst []string) map[string]string {
-	res := make(map[string]string)
+func buildReqHdr(lst []string) h1.Header {
+	var res h1.Header
 
 	for i := 0; i < len(lst); i += 2 {
-		res[lst[i]] = lst[i+1]
+		res.Add(lst[i], lst[i+1])
 	}
 	return res
 }
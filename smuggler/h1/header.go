@@ -0,0 +1,118 @@
+package h1
+
+import (
+	"sort"
+	"strings"
+)
+
+// a single header line, the name is sent as-is (no canonicalization)
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ordered list of header fields, duplicates are kept and fields are sent in list order.
+// name lookups are case-insensitive
+type Header []HeaderField
+
+// builds a header from a map, names are sorted so the result doesn't depend on map order
+func HeaderFromMap(m map[string][]string) Header {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var h Header
+	for _, k := range names {
+		for _, v := range m[k] {
+			h.Add(k, v)
+		}
+	}
+	return h
+}
+
+// appends a field, even if one with the same name exists
+func (h *Header) Add(name, value string) {
+	*h = append(*h, HeaderField{Name: name, Value: value})
+}
+
+// replaces the value of the first field with the same name (keeping its position) and removes
+// the rest, the field is appended if there is none
+func (h *Header) Set(name, value string) {
+	i := h.index(name)
+	if i < 0 {
+		h.Add(name, value)
+		return
+	}
+	(*h)[i].Value = value
+	tail := (*h)[i+1:]
+	tail.del(name)
+	*h = append((*h)[:i+1], tail...)
+}
+
+// value of the first field with the name
+func (h Header) Get(name string) string {
+	if i := h.index(name); i >= 0 {
+		return h[i].Value
+	}
+	return ""
+}
+
+// values of all fields with the name, in order
+func (h Header) Values(name string) []string {
+	var res []string
+	for _, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			res = append(res, f.Value)
+		}
+	}
+	return res
+}
+
+// removes every field with the name
+func (h *Header) Del(name string) {
+	h.del(name)
+}
+
+func (h *Header) del(name string) {
+	res := (*h)[:0]
+	for _, f := range *h {
+		if !strings.EqualFold(f.Name, name) {
+			res = append(res, f)
+		}
+	}
+	*h = res
+}
+
+func (h Header) index(name string) int {
+	for i, f := range h {
+		if strings.EqualFold(f.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	return append(Header{}, h...)
+}
+
+// header lines as sent on the wire, each line ends with CRLF. fields with an empty value
+// are skipped
+func (h Header) String() string {
+	var sb strings.Builder
+	for _, f := range h {
+		if len(f.Value) == 0 {
+			continue
+		}
+		sb.WriteString(f.Name)
+		sb.WriteString(": ")
+		sb.WriteString(f.Value)
+		sb.WriteString(RN)
+	}
+	return sb.String()
+}
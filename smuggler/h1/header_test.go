@@ -0,0 +1,62 @@
+package h1_test
+
+import (
+	"net/url"
+	"slices"
+	"smuggler/smuggler/h1"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	table := []struct {
+		name string
+		edit func(h *h1.Header)
+		want string
+	}{
+		{"duplicates", func(h *h1.Header) { h.Add("content-length", "5") }, "Host: a\r\nContent-Length: 4\r\nX: 1\r\nX: 2\r\ncontent-length: 5\r\n"},
+		{"set keeps position", func(h *h1.Header) { h.Set("x", "3") }, "Host: a\r\nContent-Length: 4\r\nX: 3\r\n"},
+		{"set appends", func(h *h1.Header) { h.Set("Y", "1") }, "Host: a\r\nContent-Length: 4\r\nX: 1\r\nX: 2\r\nY: 1\r\n"},
+		{"del", func(h *h1.Header) { h.Del("X") }, "Host: a\r\nContent-Length: 4\r\n"},
+		{"empty value", func(h *h1.Header) { h.Set("Host", "") }, "Content-Length: 4\r\nX: 1\r\nX: 2\r\n"},
+	}
+
+	for _, Case := range table {
+		t.Run(Case.name, func(t *testing.T) {
+			h := h1.Header{{Name: "Host", Value: "a"}, {Name: "Content-Length", Value: "4"}}
+			h.Add("X", "1")
+			h.Add("X", "2")
+			Case.edit(&h)
+			if got := h.String(); got != Case.want {
+				t.Errorf("Wanted: %q, Got: %q", Case.want, got)
+			}
+		})
+	}
+}
+
+func TestHeaderFromMap(t *testing.T) {
+	h := h1.HeaderFromMap(map[string][]string{"b": {"1", "2"}, "a": {"3"}, "c": {"4"}})
+	want := h1.Header{{Name: "a", Value: "3"}, {Name: "b", Value: "1"}, {Name: "b", Value: "2"}, {Name: "c", Value: "4"}}
+	if !slices.Equal(h, want) {
+		t.Errorf("Wanted: %v, Got: %v", want, h)
+	}
+	if got := h.Values("B"); !slices.Equal(got, []string{"1", "2"}) || h.Get("C") != "4" {
+		t.Errorf("unexpected lookup: %v", got)
+	}
+}
+
+func TestPayloadToString(t *testing.T) {
+	p := h1.Payload{
+		URL:    url.URL{Path: "/", RawQuery: "t=1"},
+		Method: "POST",
+		Header: h1.Header{{Name: "Host", Value: "a"}, {Name: "Content-Length", Value: "0"}},
+		HdrPl:  "Transfer-Encoding: chunked",
+		Cl:     5,
+		Body:   "0\r\n\r\n",
+	}
+	want := "POST /?t=1 HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n0\r\n\r\n"
+	for i := 0; i < 10; i++ { // the same payload is always the same bytes
+		if got := p.ToString(); got != want {
+			t.Fatalf("Wanted: %q, Got: %q", want, got)
+		}
+	}
+}
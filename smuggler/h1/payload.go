@@ -10,10 +10,10 @@ const RN = "\r\n"
 type Payload struct {
 	URL    url.URL
 	Method string
-	Header map[string]string // a key value pair of HTTP headers
-	Body   string            // body of the request
-	Cl     int               // content-length
-	HdrPl  string            // optional header payload
+	Header Header // sent in order, before HdrPl and Content-Length
+	Body   string // body of the request
+	Cl     int    // content-length
+	HdrPl  string // optional header payload
 }
 
 func (p *Payload) ToString() string {
@@ -26,12 +26,7 @@ func (p *Payload) ToString() string {
 		final = fmt.Sprintf("%s#%s", final, p.URL.Fragment)
 	}
 	final += " HTTP/1.1\r\n"
-	for k, v := range p.Header {
-		if len(v) == 0 {
-			continue
-		}
-		final += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
+	final += p.Header.String()
 	if len(p.HdrPl) > 0 {
 		final += p.HdrPl + RN
 	}
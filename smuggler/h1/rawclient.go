@@ -1,20 +1,27 @@
 package h1
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/tls"
 	"errors"
-	"log"
+	"io"
 	"net"
+	"net/http"
 	"net/url"
-	"os"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/tlsconf"
 	"strings"
+	"time"
 )
 
 type RawClient struct {
 	conn net.Conn
 }
 
-func NewClient(url *url.URL) (*RawClient, error) {
+// opens a connection to the host of url with d (nil to dial directly), opts is used for https
+// (nil for the defaults)
+func NewClient(url *url.URL, opts *tlsconf.Options, d dialer.Dialer) (*RawClient, error) {
 	if url == nil || url.Host == "" {
 		return nil, errors.New("invalid URL")
 	}
@@ -29,57 +36,49 @@ func NewClient(url *url.URL) (*RawClient, error) {
 		}
 	}
 	client := RawClient{}
+	var cfg *tls.Config
 	if url.Scheme == "https" {
-		f, err := os.OpenFile("/Users/akewakbiru/Desktop/sslkeys.log", os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
-			return nil, err
-		}
-		cfg := &tls.Config{InsecureSkipVerify: false, NextProtos: []string{"http/1.1"}, KeyLogWriter: f}
-		client.conn, err = tls.Dial("tcp", host+":"+port, cfg)
-		if err != nil {
-			return nil, err
-		}
-		if err = client.conn.(*tls.Conn).Handshake(); err != nil {
-			return nil, err
-		}
-		return &client, nil
+		cfg = opts.Config("http/1.1")
 	}
-	client.conn, err = net.Dial("tcp", host+":"+port)
+	client.conn, err = dialer.Dial(d, net.JoinHostPort(host, port), cfg, time.Second*2)
 	if err != nil {
 		return nil, err
 	}
 	return &client, nil
 }
 
-func (r *RawClient) readResponse() (string, error) {
-	b := make([]byte, 2048)
-	_, err := r.conn.Read(b)
-	if err != nil {
-		return "", err
-	}
-	return string(b), nil
+func (r *RawClient) SetDeadline(t time.Time) error {
+	return r.conn.SetDeadline(t)
 }
 
-func (r *RawClient) SendPipelinedRequests(reqs ...string) string {
+// writes all requests on the connection before reading any response, one response is read
+// per request (bodies are read in full). responses read before an error are returned with it
+func (r *RawClient) SendPipelinedRequests(reqs ...string) ([]*http.Response, error) {
 	go func() {
 		for _, req := range reqs {
 			if _, err := r.conn.Write([]byte(req)); err != nil {
-				log.Print(err)
+				return // the read side gets the error
 			}
 		}
 	}()
 
-	var sb strings.Builder
-	for i := range len(reqs) {
-		_ = i
-		str, err := r.readResponse()
+	br := bufio.NewReader(r.conn)
+	resps := make([]*http.Response, 0, len(reqs))
+	for _, req := range reqs {
+		method, _, _ := strings.Cut(req, " ")
+		resp, err := http.ReadResponse(br, &http.Request{Method: method})
+		if err != nil {
+			return resps, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			log.Print(err)
-			return sb.String()
+			return resps, err
 		}
-		sb.WriteString(str)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resps = append(resps, resp)
 	}
-	return sb.String()
+	return resps, nil
 }
 
 func (r *RawClient) Close() {
@@ -7,11 +7,11 @@ import (
 	"math"
 	"math/rand/v2"
 	"net"
-	"os"
-	"smuggler/config"
+	"net/http"
 	"smuggler/smuggler/h2"
 	"smuggler/smuggler/tests"
 	"smuggler/utils"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -21,69 +21,25 @@ type H2 struct {
 	*DesyncerImpl
 }
 
-func (h *H2) Run() bool {
-	if config.Glob.Concurrent {
-		defer h.Wg.Done()
-	}
-
-	if !h.H2Supported {
-		return false
-	}
-	// create a list of payloads and loop over them
-	priorityOrder := map[config.Priority][]tests.PTYPE{
-		config.H2CLTE: {tests.CL, tests.TE, tests.CRLF},
-		config.H2TECL: {tests.TE, tests.CL, tests.CRLF},
-	}
-
-	order, exists := priorityOrder[config.Glob.Priority]
-	if exists {
-		log.Warn().
-			Any("Priority", config.Glob.Priority).Msg("Unknown priority, defaulting to H2CLTE")
-		order = []tests.PTYPE{tests.CRLF, tests.CL, tests.TE}
-	}
-	for _, t := range order {
-		if h.run(t) {
-			return true
-		}
-	}
-	return false
-}
-
 func (h *H2) run(t tests.PTYPE) bool {
 	log.Info().Str("endpoint", h.URL.String()).Msgf("Running H2-%s desync tests...", t.String())
 	ctr := 0
-	generator := tests.Generator{}
-	pl := generator.Generate(t, config.Glob.Test)
-	for k, vv := range pl {
-		for _, v := range vv {
-			var req *h2.Request
-			if t == tests.CL {
-				req = h.newRequest(v, k)
-			} else {
-				req = h.newRequest(k, v)
-			}
-
-			if h.runTest(req, t) {
-				ctr++
-				if config.Glob.ExitEarly {
-					log.Info().
-						Str("endpoint", h.URL.String()).
-						Str("status", "success").
-						Msgf("Test stopped on success: PoC payload stored in /result/%s directory", h.URL.Hostname())
-					if config.Glob.Concurrent {
-						h.TestDone <- struct{}{}
-					}
-					return true
-				}
-			}
-			if config.Glob.Concurrent {
-				select {
-				case <-h.Ctx.Done():
-					return false
-				default:
-				}
+	for _, m := range h.mutations(t) {
+		if h.runTest(m) {
+			ctr++
+			if h.Opts.ExitEarly {
+				log.Info().
+					Str("endpoint", h.URL.String()).
+					Str("status", "success").
+					Msgf("Test stopped on success: PoC payload stored in /result/%s directory", h.URL.Hostname())
+				return true
 			}
 		}
+		select {
+		case <-h.Ctx.Done():
+			return false
+		default:
+		}
 	}
 	if ctr > 0 { // if eos, it shouldn't even come here on success
 		log.Info().
@@ -99,16 +55,18 @@ func (h *H2) run(t tests.PTYPE) bool {
 	return false
 }
 
-func (h *H2) runTest(req *h2.Request, t tests.PTYPE) bool {
+func (h *H2) runTest(m tests.Mutation) bool {
+	t := m.Type
+	req := h.newRequest(m.Key, m.Val) // the value of CL mutations is set by t.Body
 	ctr := 0
 	for {
 		t.Body(req, false)
 		ret, err := h.sendRequest(req)
-		if ret != 1 {
-			if ret == -1 {
+		if ret.Code != ProbeTimeout {
+			if ret.Code == ProbeError {
 				log.Debug().
 					Str("endpoint", h.URL.String()).Err(err).Msg("")
-			} else if ret == 2 {
+			} else if ret.Code == ProbeDisconnected {
 				log.Debug().
 					Str("endpoint", h.URL.String()).
 					Msg("disconnected before timeout")
@@ -117,24 +75,44 @@ func (h *H2) runTest(req *h2.Request, t tests.PTYPE) bool {
 		}
 		t.Body(req, true)
 		ret2, err := h.sendRequest(req)
-		if ret2 == -1 {
+		if ret2.Code == ProbeError {
 			log.Debug().
 				Str("endpoint", h.URL.String()).Err(err).Msg("")
 			return false
 		}
-		if ret2 == 0 {
+		if ret2.Code == ProbeNormal {
 			ctr++
 			if ctr < 3 {
 				continue
 			}
+			if !h.recheck(true) {
+				return false
+			}
+			ctl := h.control(req, t)
+			fresh := h.freshConn(req, t)
 			t.Body(req, false)
 			log.Info().
 				Str("endpoint", h.URL.String()).
+				Str("mutation", m.ID).
 				Msgf("Potential H2%s issue found - %s@%s://%s%s", t.String(), h.Method,
 					h.URL.Scheme, h.URL.Host, h.URL.Path)
-			// generate a report here
-			h.generateH2Report(req)
-			return true
+			f := Finding{
+				Technique: h2Technique[t],
+				Mutation:  m.ID,
+				Probes:    []Probe{*ret, *ret2},
+				Signals:   h.timingSignals(true, ctr, ctl, fresh),
+				PoC:       h.h2PoC(h2Attack(req, t, smuggledPrefix("/hopefully404"))),
+			}
+			if ctl != nil {
+				f.Controls = []Probe{*ctl}
+			}
+			f.Confidence = confidence(f.Signals)
+			if h.Opts.Confirm {
+				if f.Evidence, f.Confirmed = h.confirmH2(req, t); !f.Confirmed {
+					return false
+				}
+			}
+			return h.generateH2Report(req, f)
 		}
 		log.Debug().
 			Str("endpoint", h.URL.String()).
@@ -144,29 +122,144 @@ func (h *H2) runTest(req *h2.Request, t tests.PTYPE) bool {
 	}
 }
 
-func (h *H2) generateH2Report(req *h2.Request) {
-	if err := createDir("/result/"); err != nil {
-		log.Warn().Err(err).Msg("")
-	}
-	if err := createDir(fmt.Sprintf("/result/%s", h.URL.Hostname())); err != nil {
-		log.Warn().Err(err).Msg("")
+// H2.0: the front-end forwards the body of the DATA frames (adding a content-length when it
+// downgrades), but the back-end ignores the body of requests to the endpoint. the body is a
+// smuggled request prefix, the follow-up request on another stream gets its response when the
+// front-end reuses the poisoned back-end connection
+func (h *H2) runH20() bool {
+	log.Info().Str("endpoint", h.URL.String()).Msg("Running H2.0 desync tests...")
+
+	path := fmt.Sprintf("/hopefully404-%d", rand.Int32N(math.MaxInt32))
+	smuggled, err := h.get(path)
+	if err != nil {
+		log.Debug().Str("endpoint", h.URL.String()).Err(err).Msg("H2.0 baseline failed")
+		return false
 	}
-	pwd, err := os.Getwd()
+	normal, err := h.get(h.URL.Path)
 	if err != nil {
-		log.Warn().Err(err).Msg("")
-		return
+		log.Debug().Str("endpoint", h.URL.String()).Err(err).Msg("H2.0 baseline failed")
+		return false
+	}
+	if smuggled.Status == normal.Status {
+		log.Info().
+			Str("endpoint", h.URL.String()).
+			Str("status", "undetermined").
+			Msgf("H2.0 desync tests skipped: %s and %s both return %d", path, h.URL.Path, normal.Status)
+		return false
+	}
+
+	methods := []string{h.Method}
+	if h.Method != "GET" {
+		methods = append(methods, "GET") // bodies of GET requests are the most likely to be ignored
+	}
+	for _, method := range methods {
+		if h.h20(method, path, smuggled.Status) {
+			log.Info().
+				Str("endpoint", h.URL.String()).
+				Str("status", "success").
+				Msgf("finished H2.0 desync tests: PoC payload stored in /result/%s directory", h.URL.Hostname())
+			return true
+		}
+		select {
+		case <-h.Ctx.Done():
+			return false
+		default:
+		}
 	}
-	fname := fmt.Sprintf("%s/result/%s/%ss", pwd, h.URL.Hostname(), h.URL.Query().Get("t"))
-	file, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	log.Info().
+		Str("endpoint", h.URL.String()).
+		Str("status", "failure").
+		Msg("finished H2.0 desync tests: no issues found")
+	return false
+}
+
+// status is the response status of the smuggled request when it's sent on its own
+func (h *H2) h20(method, path string, status int) bool {
+	req := h.newRequest("", "")
+	req.Method = method
+	req.Body = []byte(smuggledPrefix(path))
+
+	var ret, followUp *Probe
+	var err error
+	for i := 0; i < 2; i++ { // the first might be a fluke (a back-end connection that isn't reused)
+		if ret, err = h.sendRequest(req); ret.Code != ProbeNormal {
+			log.Debug().Str("endpoint", h.URL.String()).Err(err).Msgf("H2.0 %s request failed", method)
+			return false
+		}
+		if followUp, err = h.get(h.URL.Path); err != nil {
+			log.Debug().Str("endpoint", h.URL.String()).Err(err).Msg("H2.0 follow-up request failed")
+			return false
+		}
+		if followUp.Status != status {
+			return false // the follow-up wasn't affected
+		}
+	}
+
+	log.Info().
+		Str("endpoint", h.URL.String()).
+		Msgf("Potential H2.0 issue found - %s@%s://%s%s (follow-up got %d)", method,
+			h.URL.Scheme, h.URL.Host, h.URL.Path, followUp.Status)
+	victim := h.newRequest("", "")
+	victim.Method = "GET"
+	return h.generateH2Report(req, Finding{
+		Technique:  H20,
+		Probes:     []Probe{*ret, *followUp},
+		Confidence: pairConfidence(2),
+		Confirmed:  true,
+		Evidence:   []Probe{*ret, *followUp},
+		PoC:        []PoCRequest{h2PoC(0, req), h2PoC(0, victim)}, // streams of one connection
+	})
+}
+
+// sends the timing request of t without the mutated header, nil if it failed
+func (h *H2) control(req *h2.Request, t tests.PTYPE) *Probe {
+	t.Body(req, false)
+	c := *req
+	c.Payload = nil
+	ret, err := h.sendRequest(&c)
+	if ret.Code == ProbeError {
+		log.Debug().Err(err).Str("endpoint", h.URL.String()).Msg("control request failed")
+		return nil
+	}
+	return ret
+}
+
+// repeats a timeout/normal pair on a new connection, the hit must not depend on the state of
+// the shared one
+func (h *H2) freshConn(req *h2.Request, t tests.PTYPE) bool {
+	timeout, _ := h.timeouts(true)
+	c, err := h2.Transport{Timeout: timeout, TLS: h.Opts.TLS, Dialer: h.Opts.Dialer}.Dial(h.URL, h2.H2)
 	if err != nil {
-		log.Warn().Err(err).Msg("")
-		return
+		return false
 	}
-	defer file.Close()
+	defer c.Close()
+
+	t.Body(req, false)
+	ret, _ := h.sendWith(c.Do, req)
+	t.Body(req, true)
+	ret2, _ := h.sendWith(c.Do, req)
+	return ret.Code == ProbeTimeout && ret2.Code == ProbeNormal
+}
 
-	if _, err := file.WriteString(utils.GetH2RequestSummary(req)); err != nil {
-		log.Warn().Err(err).Msg("Failed to write report to file")
+var h2Technique = map[tests.PTYPE]Technique{
+	tests.CL:   H2CL,
+	tests.TE:   H2TE,
+	tests.CRLF: H2CRLF,
+}
+
+// records a finding for an h2 request, the PoC is also stored in the report directory. false if
+// the finding is under the confidence threshold
+func (h *H2) generateH2Report(req *h2.Request, f Finding) bool {
+	summary := utils.GetH2RequestSummary(req)
+	if req.Payload != nil && len(f.Header) == 0 {
+		f.Header = fmt.Sprintf("%s: %s", req.Payload.Key, req.Payload.Val)
+	}
+	f.Request = summary
+	if !h.addFinding(f) {
+		return false
 	}
+	h.writeReport(req.URL.Query().Get("t"), summary)
+	return true
 }
 
 func (h *H2) newRequest(key, val string) *h2.Request {
@@ -175,8 +268,10 @@ func (h *H2) newRequest(key, val string) *h2.Request {
 		Method: h.Method,
 	}
 	req.Hdrs = utils.CloneMap(h.Hdr)
-	for k, vv := range config.Glob.Hdr {
-		req.Hdrs[k] = append(req.Hdrs[k], vv...)
+	for k, vv := range h.Opts.Headers { // per-host headers replace global ones with the same name
+		if !hasHeader(h.Hdr, k) {
+			req.Hdrs[k] = append(req.Hdrs[k], vv...)
+		}
 	}
 	if len(key) > 0 {
 		req.Payload = &h2.Payload{Key: key, Val: val}
@@ -184,33 +279,104 @@ func (h *H2) newRequest(key, val string) *h2.Request {
 	return req
 }
 
-func (h *H2) sendRequest(req *h2.Request) (int, error) {
-	t := h2.Transport{}
-	req.URL = h.URL
-	q := req.URL.Query()
+func hasHeader(m map[string][]string, name string) bool {
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sends req on the h2 connection of the target, a new one is dialed when the server closed it
+func (d *DesyncerImpl) h2Do(req *h2.Request) (*http.Response, error) {
+	d.h2mu.Lock()
+	if d.h2c == nil || d.h2c.Closed() {
+		if d.h2c != nil {
+			d.h2c.Close()
+		}
+		c, err := h2.Transport{Timeout: d.Opts.Timeout, TLS: d.Opts.TLS, Dialer: d.Opts.Dialer}.Dial(d.URL, h2.H2)
+		if err != nil {
+			d.h2mu.Unlock()
+			return nil, err
+		}
+		d.h2c = c
+	}
+	c := d.h2c
+	d.h2mu.Unlock()
+	r := *req // the timeout is per stream, other detectors share the connection
+	r.Timeout, _ = d.timeouts(true)
+	return c.Do(&r)
+}
+
+// sends req on a new connection, closed once the response is received
+func (d *DesyncerImpl) h2RoundTrip(req *h2.Request) (*http.Response, error) {
+	timeout, _ := d.timeouts(true)
+	return h2.Transport{Timeout: timeout, TLS: d.Opts.TLS, Dialer: d.Opts.Dialer}.RoundTrip(req)
+}
+
+func (d *DesyncerImpl) closeH2() {
+	d.h2mu.Lock()
+	defer d.h2mu.Unlock()
+	if d.h2c != nil {
+		d.h2c.Close()
+		d.h2c = nil
+	}
+}
+
+func (h *H2) sendRequest(req *h2.Request) (*Probe, error) {
+	return h.sendWith(h.h2Do, req)
+}
+
+// sends req with do (the shared connection or another one)
+func (h *H2) sendWith(do func(*h2.Request) (*http.Response, error), req *h2.Request) (*Probe, error) {
+	ret, err := h.probe(do, req)
+	sent := h2PoC(0, req) // with the cache buster it got
+	ret.H2 = &sent
+	return ret, err
+}
+
+func (h *H2) probe(do func(*h2.Request) (*http.Response, error), req *h2.Request) (*Probe, error) {
+	u := *h.URL // h.URL is shared by every request of the target
+	if req.URL != nil {
+		u.Path = req.URL.Path
+	}
+	q := u.Query()
 	q.Set("t", fmt.Sprintf("%d", rand.Int32N(math.MaxInt32))) // avoid caching
-	req.URL.RawQuery = q.Encode()
+	u.RawQuery = q.Encode()
+	req.URL = &u
+	raw := utils.GetH2RequestSummary(req)
+	_, early := h.timeouts(true)
+	release, err := h.acquire()
+	if err != nil {
+		return newProbe(ProbeError, raw, 0, 0), err
+	}
+	defer release()
 	start := time.Now()
-	resp, err := t.RoundTrip(req)
+	resp, err := do(req)
+	diff := time.Since(start)
+	h.observe(resp)
 	if err != nil {
 		var netErr net.Error // check for timeout error
 		if errors.As(err, &netErr) && netErr.Timeout() {
-			return 1, err
+			return newProbe(ProbeTimeout, raw, diff, 0), err
 		}
-		return 0, err
+		return newProbe(ProbeError, raw, diff, 0), err
 	}
-	diff := time.Since(start)
 
 	sample := make([]byte, 100)
-	if _, err := resp.Body.Read(sample); err != nil && err != io.EOF {
-		return -1, err
+	n, err := resp.Body.Read(sample)
+	if err != nil && err != io.EOF {
+		return newProbe(ProbeError, raw, diff, resp.StatusCode), err
 	}
 	resp.Body.Close()
-	if len(sample) == 0 {
-		if diff < config.Glob.Timeout-time.Second {
-			return 2, nil
+	if n == 0 { // no body
+		if diff < early {
+			return newProbe(ProbeDisconnected, raw, diff, resp.StatusCode), nil
 		}
-		return 1, nil
+		return newProbe(ProbeTimeout, raw, diff, resp.StatusCode), nil
 	}
-	return 0, nil
+	ret := newProbe(ProbeNormal, raw, diff, resp.StatusCode)
+	ret.Response = dumpResponse(resp)
+	return ret, nil
 }
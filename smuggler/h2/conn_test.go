@@ -0,0 +1,177 @@
+package h2_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"smuggler/smuggler/h2"
+	"smuggler/smuggler/lab"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func dialLab(t *testing.T, profile string) (*h2.ClientConn, *url.URL) {
+	l, err := lab.Start(lab.Profiles[profile], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	u, err := url.Parse(l.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := h2.Transport{Timeout: time.Second}.Dial(u, h2.H2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, u
+}
+
+func TestClientConnStreams(t *testing.T) {
+	c, u := dialLab(t, "h2safe")
+	req := func(path string) *h2.Request {
+		return &h2.Request{Method: http.MethodGet, URL: &url.URL{Scheme: u.Scheme, Host: u.Host, Path: path}}
+	}
+
+	// concurrent streams on the same connection
+	paths := []string{"/", "/404", "/", "/404"}
+	streams := make([]*h2.Stream, len(paths))
+	for i, p := range paths {
+		s, err := c.NewStream(req(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams[i] = s
+	}
+	for i, s := range streams {
+		if s.ID != uint32(2*i+1) {
+			t.Errorf("Wanted: stream %d, Got: %d", 2*i+1, s.ID)
+		}
+		resp, err := s.Response()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		want := http.StatusOK
+		if paths[i] == "/404" {
+			want = http.StatusNotFound
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: Wanted: %d, Got: %d", paths[i], want, resp.StatusCode)
+		}
+	}
+
+	// sequential requests reuse it too
+	for i := 0; i < 3; i++ {
+		resp, err := c.Do(req("/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Wanted: %d, Got: %d", http.StatusOK, resp.StatusCode)
+		}
+	}
+	if c.Closed() {
+		t.Error("Wanted an open connection")
+	}
+}
+
+func TestClientConnTimeout(t *testing.T) {
+	c, u := dialLab(t, "h2cl")
+	hang := &h2.Request{
+		Method:  http.MethodPost,
+		URL:     &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"},
+		Body:    []byte("x=1"),
+		Payload: &h2.Payload{Key: "content-length", Val: "10"}, // the back-end waits for the rest
+	}
+	_, err := c.Do(hang)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Wanted a timeout, Got: %v", err)
+	}
+
+	// a timeout per stream, the one of the connection is unchanged
+	short := *hang
+	short.Timeout = time.Millisecond * 200
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		c.Do(hang)
+		done <- time.Since(start)
+	}()
+	start := time.Now()
+	if _, err := c.Do(&short); !errors.As(err, &netErr) || !netErr.Timeout() || time.Since(start) > time.Millisecond*800 {
+		t.Errorf("Wanted a timeout after %v, Got: %v after %v", short.Timeout, err, time.Since(start))
+	}
+	if d := <-done; d < time.Millisecond*900 {
+		t.Errorf("Wanted the concurrent stream to time out after 1s, Got: %v", d)
+	}
+
+	// only the stream is reset
+	resp, err := c.Do(&h2.Request{Method: http.MethodGet, URL: &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Wanted: %d, Got: %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+// a response ending the stream in its HEADERS frame is only complete after the CONTINUATION frames
+func TestClientConnContinuation(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	srv.Close() // only its certificate is used
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := io.ReadFull(conn, make([]byte, len(http2.ClientPreface))); err != nil {
+			return
+		}
+		fr := http2.NewFramer(conn, conn)
+		fr.WriteSettings()
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if h, ok := f.(*http2.HeadersFrame); ok {
+				var block bytes.Buffer
+				enc := hpack.NewEncoder(&block)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "418"})
+				enc.WriteField(hpack.HeaderField{Name: "x-a", Value: "b"})
+				b := block.Bytes()
+				fr.WriteHeaders(http2.HeadersFrameParam{StreamID: h.StreamID, BlockFragment: b[:2], EndStream: true})
+				fr.WriteContinuation(h.StreamID, true, b[2:])
+			}
+		}
+	}()
+
+	u := &url.URL{Scheme: "https", Host: ln.Addr().String(), Path: "/"}
+	resp, err := h2.Transport{Timeout: time.Second}.RoundTrip(&h2.Request{Method: http.MethodGet, URL: u})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTeapot || resp.Header.Get("x-a") != "b" {
+		t.Errorf("Wanted: 418 with x-a, Got: %d %v", resp.StatusCode, resp.Header)
+	}
+}
@@ -3,7 +3,6 @@ package h2
 import (
 	"bufio"
 	"bytes"
-	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
@@ -14,6 +13,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/tlsconf"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,40 +34,56 @@ const (
 // use verbose logging to log all frames
 // export GODEBUG=http2debug=2
 
-type clientConn struct {
+// a persistent connection, every request is sent on its own stream. streams can be opened
+// concurrently, e.g. an attack and a victim request over the same upstream path
+type ClientConn struct {
+	conn   net.Conn
 	framer *http2.Framer
-	muBw   sync.Mutex
+	muBw   sync.Mutex // guards writes, the encoder and stream IDs (streams must be opened in order)
 	bw     *bufio.Writer
 	br     *bufio.Reader
 
-	hbuf bytes.Buffer
-	henc *hpack.Encoder
 	hdec *hpack.Decoder
-
-	nextRes http.Header
+	cur  *Stream // stream of the header block being decoded, only used by the read loop
 
 	mu           sync.Mutex
-	streams      map[uint32]*cstream
+	streams      map[uint32]*Stream
 	nextStreamID uint32
+	goAway       bool // no new streams can be opened
 
 	maxFrameSize    uint32
 	maxDynTableSize uint32
 
-	status  int
-	request *http.Request
+	timeout time.Duration
+
+	readerDone  chan struct{}
+	readerError error // set before readerDone is closed
+}
 
-	readerDone chan struct{}
+const defaultTimeout = time.Second * 5
 
-	readerError error
+type Transport struct {
+	Timeout time.Duration    // dial and per-request timeout, 5s if zero
+	TLS     *tlsconf.Options // nil for the defaults
+	Dialer  dialer.Dialer    // nil to dial the target directly
 }
 
-type Transport struct{}
+// a request sent on a connection
+type Stream struct {
+	ID uint32
 
-type cstream struct { // adding window would be a good idea but i don't care
-	ID   uint32
-	resc chan *http.Response
-	pr   *PipeReader
-	pw   *PipeWriter
+	c        *ClientConn
+	req      *http.Request
+	deadline time.Time
+
+	header http.Header
+	status int
+	pr     *PipeReader
+	pw     *PipeWriter
+
+	done chan struct{} // closed when res or err is set
+	res  *http.Response
+	err  error
 }
 
 // used for all payload construction
@@ -84,6 +101,8 @@ type Request struct {
 	Body   []byte
 	Mode   Mode // is it h2/h2c
 
+	Timeout time.Duration // of the stream, the timeout of the connection if zero
+
 	Payload *Payload // send it as a header as-is (in lowercase) // strip the first space if found (in value)
 }
 
@@ -133,214 +152,335 @@ func BuildH2CPayload(req *Request) (string, error) {
 	return final, nil
 }
 
-// use my own request header
+// sends req on a new connection, the connection is closed once the response is received
 func (t Transport) RoundTrip(req *Request) (*http.Response, error) {
-	if req.URL.Scheme == "https" && req.Mode == H2C {
+	c, err := t.Dial(req.URL, req.Mode)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.Do(req)
+}
+
+// sends r on a new connection to the host of u
+func (t Transport) RoundTripRaw(u *url.URL, mode Mode, r *RawRequest) (*http.Response, error) {
+	c, err := t.Dial(u, mode)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	return c.DoRaw(r)
+}
+
+func (t Transport) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return t.Timeout
+}
+
+// opens a connection to the host of u, for h2c the connection is upgraded with a GET of u
+func (t Transport) Dial(u *url.URL, mode Mode) (*ClientConn, error) {
+	if u.Scheme == "https" && mode == H2C {
 		return nil, errors.New("h2c: unsupported scheme") // h2 cleartext
 	}
 
 	var tHost string
-	if strings.Contains(req.URL.Host, "\r\n") {
-		tHost = strings.Split(req.URL.Host, "\r\n")[0]
+	if strings.Contains(u.Host, "\r\n") {
+		tHost = strings.Split(u.Host, "\r\n")[0]
 	} else {
-		tHost = req.URL.Host
+		tHost = u.Host
 	}
 	host, port, err := net.SplitHostPort(tHost)
 	if err != nil {
 		host = tHost
-		if req.Mode == H2C {
+		if mode == H2C {
 			port = "80"
 		} else {
 			port = "443"
 		}
 	}
 
-	f, _ := os.OpenFile("/Users/akewakbiru/Desktop/sslkeys.log", os.O_WRONLY|os.O_APPEND, 0644)
-	cfg := tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"h2"},
-		KeyLogWriter:       f,
+	var cfg *tls.Config
+	if mode != H2C {
+		cfg = t.TLS.Config("h2")
 	}
-
-	var conn net.Conn
-	if req.Mode == H2C {
-		conn, err = net.Dial("tcp", host+":"+port)
-		if err != nil {
-			return nil, err
-		}
-		defer conn.Close()
-	} else {
-		conn, err = tls.Dial("tcp", host+":"+port, &cfg)
-		if err != nil {
-			return nil, err
-		}
-		defer conn.Close()
-		if err := conn.(*tls.Conn).Handshake(); err != nil {
-			return nil, err
-		}
-	}
-
-	if err := conn.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
+	conn, err := dialer.Dial(t.Dialer, net.JoinHostPort(host, port), cfg, t.timeout())
+	if err != nil {
 		return nil, err
 	}
 
-	client := clientConn{
-		bw: bufio.NewWriter(conn),
-		br: bufio.NewReader(conn),
+	c := &ClientConn{
+		conn: conn,
+		bw:   bufio.NewWriter(conn),
+		br:   bufio.NewReader(conn),
 
 		nextStreamID: 1,
 
-		streams:    make(map[uint32]*cstream),
+		streams:    make(map[uint32]*Stream),
 		readerDone: make(chan struct{}),
 
 		maxFrameSize: 1 << 14,
 
 		maxDynTableSize: 4096,
 
-		request: BuildReq(req),
+		timeout: t.timeout(),
 	}
-
-	if req.Mode == H2C {
-		p, err := BuildH2CPayload(req)
-		if err != nil {
-			return nil, err
-		}
-		if _, err := conn.Write([]byte(p)); err != nil {
+	if mode == H2C {
+		if err := c.upgrade(u); err != nil {
+			conn.Close()
 			return nil, err
 		}
-		res := make([]byte, 1024)
-		if _, err := conn.Read(res); err != nil {
-			return nil, err
-		}
-		if !strings.Contains(string(res), "HTTP/1.1 101 Switching Protocols") {
-			return nil, fmt.Errorf("h2c is not supported: %s", req.URL.String())
-		}
 	}
 
-	client.henc = hpack.NewEncoder(&client.hbuf)
-	client.hdec = hpack.NewDecoder(client.maxDynTableSize, client.onNewHeaderField)
-	client.nextRes = make(http.Header)
+	c.hdec = hpack.NewDecoder(c.maxDynTableSize, c.onNewHeaderField)
+	c.framer = http2.NewFramer(c.bw, c.br)
 
-	client.framer = http2.NewFramer(client.bw, client.br)
-	if _, err := client.bw.Write([]byte(http2.ClientPreface)); err != nil {
+	if _, err := c.bw.Write([]byte(http2.ClientPreface)); err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("error Sending Preface: %v", err)
 	}
+	if err := c.framer.WriteSettings(
+		http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 15},
+		http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+		http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error sending HTTP/2 Settings frame: %v", err)
+	}
+	if err := c.framer.WriteWindowUpdate(0, 1<<15); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error sending HTTP/2 WINDOW_UPDATE frame: %v", err)
+	}
+	if err := c.bw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	if req.Mode == H2 {
-		if err := client.framer.WriteSettings(
-			http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 15},
-			http2.Setting{ID: http2.SettingEnablePush, Val: 0},
-			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100}); err != nil {
-			return nil, fmt.Errorf("error sending HTTP/2 Settings frame: %v", err)
-		}
+	go c.readLoop()
+	return c, nil
+}
 
-		if err := client.framer.WriteWindowUpdate(0, 1<<15); err != nil {
-			return nil, fmt.Errorf("error sending HTTP/2 WINDOW_UPDATE frame: %v", err)
-		}
-		client.bw.Flush()
+// the response to the upgrade request comes on stream 1, it's dropped
+func (c *ClientConn) upgrade(u *url.URL) error {
+	p, err := BuildH2CPayload(&Request{URL: u, Method: http.MethodGet})
+	if err != nil {
+		return err
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := io.WriteString(c.conn, p); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(c.br, nil) // the frames that follow stay buffered
+	if err != nil {
+		return err
 	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("h2c is not supported: %s", u.String())
+	}
+	c.nextStreamID = 3
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*6)
-	defer cancel()
+func (c *ClientConn) Close() error {
+	return c.conn.Close()
+}
 
-	var hasbody bool
-	if req.Method == http.MethodGet || req.Method == http.MethodHead || req.Method == http.MethodOptions ||
-		req.Method == http.MethodTrace || req.Body == nil {
-		hasbody = false
+// reports whether new streams can't be opened anymore
+func (c *ClientConn) Closed() bool {
+	select {
+	case <-c.readerDone:
+		return true
+	default:
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.goAway
+}
 
-	var body []byte = req.Body
-	hasbody = len(body) > 0
+// sends req on a new stream and waits for the response
+func (c *ClientConn) Do(req *Request) (*http.Response, error) {
+	s, err := c.NewStream(req)
+	if err != nil {
+		return nil, err
+	}
+	return s.Response()
+}
 
-	go client.readLoop()
+// sends r on a new stream and waits for the response
+func (c *ClientConn) DoRaw(r *RawRequest) (*http.Response, error) {
+	s, err := c.NewRawStream(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.Response()
+}
 
-	cs := client.Stream()
-	hdrs := client.encodeHeaders(req)
-	first := true
+// opens a stream and sends req (headers and body), the response is read with Response
+func (c *ClientConn) NewStream(req *Request) (*Stream, error) {
+	return c.newStream(NewRawRequest(req), BuildReq(req))
+}
+
+// opens a stream and sends the frames of r
+func (c *ClientConn) NewRawStream(r *RawRequest) (*Stream, error) {
+	return c.newStream(r, r.httpRequest())
+}
+
+func (c *ClientConn) newStream(r *RawRequest, req *http.Request) (*Stream, error) {
+	c.muBw.Lock()
+	defer c.muBw.Unlock()
+
+	c.mu.Lock()
+	if c.goAway {
+		c.mu.Unlock()
+		return nil, errors.New("connection is going away")
+	}
+	timeout := c.timeout
+	if r.Timeout > 0 {
+		timeout = r.Timeout
+	}
+	s := &Stream{
+		ID:       c.nextStreamID,
+		c:        c,
+		req:      req,
+		deadline: time.Now().Add(timeout),
+		header:   make(http.Header),
+		done:     make(chan struct{}),
+	}
+	s.pr, s.pw = NewP(nil)
+	c.nextStreamID += 2
+	c.streams[s.ID] = s
+	c.mu.Unlock()
 
-	endHeaders := true
-	endStream := !hasbody
-	if req.Mode == H2C {
-		body = []byte{}
-		hdrs = []byte{}
+	if err := c.writeRaw(s, r); err != nil {
+		c.mu.Lock()
+		delete(c.streams, s.ID)
+		c.mu.Unlock()
+		return nil, err
 	}
+	return s, nil
+}
 
-	for len(hdrs) > 0 { // send header
+// the caller holds muBw
+func (c *ClientConn) writeRaw(s *Stream, r *RawRequest) error {
+	body := r.Body
+	hdrs := r.HeaderBlock()
+	split := int(c.maxFrameSize)
+	if r.HeaderSplit > 0 && r.HeaderSplit < split {
+		split = r.HeaderSplit
+	}
+	first := true
+	for first || len(hdrs) > 0 { // send header
 		chunk := hdrs
-		if len(chunk) > int(client.maxFrameSize) {
-			client.muBw.Lock()
-			chunk = chunk[:client.maxFrameSize]
-			client.muBw.Unlock()
+		if len(chunk) > split {
+			chunk = chunk[:split]
 		}
 		hdrs = hdrs[len(chunk):]
-		endHeaders = len(hdrs) == 0
+		endHeaders := len(hdrs) == 0
 		if first {
-			hf := http2.HeadersFrameParam{
-				StreamID:      cs.ID,
+			first = false
+			if err := c.framer.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      s.ID,
 				EndHeaders:    endHeaders,
 				BlockFragment: chunk,
-				EndStream:     endStream,
-			}
-			client.muBw.Lock()
-			if err := client.framer.WriteHeaders(hf); err != nil {
-				client.readerError = err
-				client.muBw.Unlock()
-				return nil, err
+				EndStream:     len(body) == 0,
+			}); err != nil {
+				return err
 			}
-			client.bw.Flush()
-			client.muBw.Unlock()
-		} else {
-			client.muBw.Lock()
-			if err := client.framer.WriteContinuation(cs.ID, endHeaders, chunk); err != nil {
-				client.readerError = err
-				client.muBw.Unlock()
-				return nil, err
-			}
-			client.bw.Flush()
-			client.muBw.Unlock()
+		} else if err := c.framer.WriteContinuation(s.ID, endHeaders, chunk); err != nil {
+			return err
 		}
 	}
-	// send DATA
-	if err := client.writeData(cs, body); err != nil {
-		client.muBw.Lock()
-		client.readerError = err
-		client.muBw.Unlock()
-	}
 
-	select {
-	case res := <-cs.resc:
-		return res, client.readerError
-	case <-client.readerDone:
-		return nil, client.readerError
-	case <-ctx.Done():
-		return nil, errors.New("request timed out")
+	// send DATA, the flow control window of the server is ignored
+	split = int(c.maxFrameSize) - int(r.Padding) - 1 // the pad length byte
+	if r.DataSplit > 0 && r.DataSplit < split {
+		split = r.DataSplit
 	}
-}
-
-func (c *clientConn) writeData(cs *cstream, body []byte) error {
-	endStream := false
-	for len(body) > 0 { // send header
+	var pad []byte
+	if r.Padding > 0 {
+		pad = make([]byte, r.Padding)
+	}
+	for len(body) > 0 {
 		chunk := body
-		if len(chunk) > int(c.maxFrameSize) {
-			chunk = chunk[:c.maxFrameSize]
-		} else {
-			endStream = true
+		if len(chunk) > split {
+			chunk = chunk[:split]
 		}
 		body = body[len(chunk):]
-		c.muBw.Lock()
-		if err := c.framer.WriteData(cs.ID, endStream, chunk); err != nil {
-			c.muBw.Unlock()
+		if err := c.framer.WriteDataPadded(s.ID, len(body) == 0, chunk, pad); err != nil {
 			return err
 		}
+	}
+	return c.bw.Flush()
+}
+
+// waits for the response, the stream is reset if it doesn't come before the timeout
+func (s *Stream) Response() (*http.Response, error) {
+	timer := time.NewTimer(time.Until(s.deadline))
+	defer timer.Stop()
+
+	select {
+	case <-s.done:
+		return s.res, s.err
+	case <-s.c.readerDone:
+		select {
+		case <-s.done:
+			return s.res, s.err
+		default:
+		}
+		if s.c.readerError == nil {
+			return nil, errors.New("connection closed")
+		}
+		return nil, s.c.readerError
+	case <-timer.C:
+		s.c.cancel(s)
+		return nil, fmt.Errorf("stream %d: request timed out: %w", s.ID, os.ErrDeadlineExceeded)
+	}
+}
+
+// resets a stream we gave up on, the connection stays usable
+func (c *ClientConn) cancel(s *Stream) {
+	c.mu.Lock()
+	delete(c.streams, s.ID)
+	c.mu.Unlock()
+
+	c.muBw.Lock()
+	defer c.muBw.Unlock()
+	if err := c.framer.WriteRSTStream(s.ID, http2.ErrCodeCancel); err == nil {
 		c.bw.Flush()
-		c.muBw.Unlock()
 	}
-	return nil
 }
 
-func (c *clientConn) readLoop() {
+// delivers the response (or err) of a stream
+func (c *ClientConn) finish(s *Stream, err error) {
+	c.mu.Lock()
+	delete(c.streams, s.ID)
+	c.mu.Unlock()
+
+	if err != nil {
+		s.pw.CloseWithError(err)
+		s.err = err
+	} else {
+		s.pw.Close()
+		s.res = &http.Response{
+			Header:     s.header,
+			StatusCode: s.status,
+			Status:     http.StatusText(s.status),
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			ProtoMinor: 0,
+			Body:       s.pr,
+			Request:    s.req,
+		}
+	}
+	close(s.done)
+}
+
+func (c *ClientConn) readLoop() {
 	defer close(c.readerDone)
 
+	endAfterHeaders := false // the HEADERS frame ended the stream, the block goes on in CONTINUATION frames
 	for {
 		fr, err := c.framer.ReadFrame()
 		if err != nil {
@@ -348,81 +488,116 @@ func (c *clientConn) readLoop() {
 			return
 		}
 
-		cs := c.streamByID(fr.Header().StreamID)
+		s := c.streamByID(fr.Header().StreamID)
 		streamEnded := false
 		switch f := fr.(type) {
 		case *http2.HeadersFrame:
-			cs.pr, cs.pw = NewP(nil)
-			c.hdec.Write(f.HeaderBlockFragment())
+			c.cur = s
+			if s != nil && s.status >= 200 {
+				c.cur = nil // trailers are dropped
+			} else if s != nil { // 1xx responses are followed by the final one
+				s.header = make(http.Header)
+				s.status = 0
+			}
+			if _, err := c.hdec.Write(f.HeaderBlockFragment()); err != nil {
+				c.readerError = err
+				return
+			}
 			streamEnded = f.StreamEnded()
+			if streamEnded && !f.HeadersEnded() {
+				endAfterHeaders, streamEnded = true, false // the response isn't complete yet
+			}
 		case *http2.ContinuationFrame:
-			c.hdec.Write(f.HeaderBlockFragment())
+			if _, err := c.hdec.Write(f.HeaderBlockFragment()); err != nil {
+				c.readerError = err
+				return
+			}
+			if f.HeadersEnded() {
+				endAfterHeaders, streamEnded = false, endAfterHeaders
+			}
 		case *http2.SettingsFrame:
 			if err := c.SettingsFrameHandler(f); err != nil {
-				c.muBw.Lock()
 				c.readerError = err
-				c.muBw.Unlock()
 				return
 			}
 		case *http2.DataFrame:
 			streamEnded = f.StreamEnded()
 			data := f.Data()
-			size := len(data)
-			if _, err := cs.pw.Write(data); err != nil {
-				log.Print(err)
-			}
-			if !streamEnded && size > 1024 {
-				c.muBw.Lock()
-				// i am not sure if i even need to send this one as my conn window size is 1 << 31 - 1 bytes ~ 2 Gb
-				if err := c.framer.WriteWindowUpdate(0, uint32(size)); err != nil {
+			if s != nil {
+				if _, err := s.pw.Write(data); err != nil {
 					log.Print(err)
 				}
-				if err := c.framer.WriteWindowUpdate(cs.ID, uint32(size)); err != nil {
-					log.Print(err)
-				}
-				c.bw.Flush()
-				c.muBw.Unlock()
+			}
+			if err := c.windowUpdate(s, uint32(len(data)), streamEnded); err != nil {
+				c.readerError = err
+				return
 			}
 		case *http2.RSTStreamFrame:
-			if f.ErrCode != 0 {
-				c.readerError = fmt.Errorf("%s frame received with error code: %d (%s)", f.Header().Type.String(),
-					f.ErrCode, f.ErrCode.String())
+			if s != nil {
+				c.finish(s, fmt.Errorf("%s frame received with error code: %d (%s)", f.Header().Type.String(),
+					f.ErrCode, f.ErrCode.String()))
 			}
-			return
 		case *http2.GoAwayFrame:
 			if f.ErrCode != 0 {
 				c.readerError = fmt.Errorf("%s frame received with error code: %d (%s)", f.Header().Type.String(),
 					f.ErrCode, f.ErrCode.String())
+				return
+			}
+			c.closeStreamsAfter(f.LastStreamID)
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				c.muBw.Lock()
+				if err := c.framer.WritePing(true, f.Data); err == nil {
+					c.bw.Flush()
+				}
+				c.muBw.Unlock()
 			}
-			return
 		case *http2.UnknownFrame:
 			log.Print("UNKNOWN frame received")
 		default:
 		}
-		if streamEnded {
-			if cs != nil {
-				cs.pw.Close()
-			}
-			if cs == nil {
-				c.readerError = errors.New("couldn't find stream")
-				return
-			} else {
-				cs.resc <- &http.Response{
-					Header:     c.nextRes,
-					StatusCode: c.status,
-					Status:     http.StatusText(c.status),
-					Proto:      "HTTP/2.0",
-					ProtoMajor: 2,
-					ProtoMinor: 0,
-					Body:       cs.pr,
-					Request:    c.request,
-				}
-			}
+		if streamEnded && s != nil {
+			c.finish(s, nil)
+		}
+	}
+}
+
+// after a graceful GOAWAY, streams above last won't be processed and no stream can be opened
+func (c *ClientConn) closeStreamsAfter(last uint32) {
+	c.mu.Lock()
+	c.goAway = true
+	var failed []*Stream
+	for id, s := range c.streams {
+		if id > last {
+			failed = append(failed, s)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, s := range failed {
+		c.finish(s, errors.New("stream refused by GOAWAY"))
+	}
+}
+
+// gives the received bytes back to the server, on the connection and the stream (unless it ended)
+func (c *ClientConn) windowUpdate(s *Stream, n uint32, ended bool) error {
+	if n == 0 {
+		return nil
+	}
+	c.muBw.Lock()
+	defer c.muBw.Unlock()
+	if err := c.framer.WriteWindowUpdate(0, n); err != nil {
+		return err
+	}
+	if s != nil && !ended {
+		if err := c.framer.WriteWindowUpdate(s.ID, n); err != nil {
+			return err
 		}
 	}
+	return c.bw.Flush()
 }
 
-func (c *clientConn) SettingsFrameHandler(f *http2.SettingsFrame) error {
+func (c *ClientConn) SettingsFrameHandler(f *http2.SettingsFrame) error {
 	f.ForeachSetting(func(s http2.Setting) error {
 		if http2.SettingMaxFrameSize == s.ID {
 			c.muBw.Lock()
@@ -433,67 +608,33 @@ func (c *clientConn) SettingsFrameHandler(f *http2.SettingsFrame) error {
 	})
 	if !f.IsAck() {
 		c.muBw.Lock()
+		defer c.muBw.Unlock()
 		if err := c.framer.WriteSettingsAck(); err != nil {
-			c.muBw.Unlock()
 			return err
 		}
 		c.bw.Flush()
-		c.muBw.Unlock()
 	}
 	return nil
 }
 
-func (c *clientConn) Stream() *cstream {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	cs := &cstream{
-		ID:   c.nextStreamID,
-		resc: make(chan *http.Response, 1),
-	}
-	c.nextStreamID += 2
-	c.streams[cs.ID] = cs
-	return cs
-}
-
-func (c *clientConn) streamByID(id uint32) *cstream {
+func (c *ClientConn) streamByID(id uint32) *Stream {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.streams[id]
 }
 
-func (c *clientConn) encodeHeaders(req *Request) []byte {
-	c.writeHeader(":authority", req.URL.Host)
-	c.writeHeader(":method", req.Method)
-	c.writeHeader(":path", req.URL.RequestURI())
-	c.writeHeader(":scheme", req.URL.Scheme)
-	for k, vv := range req.Hdrs {
-		for _, v := range vv {
-			c.writeHeader(strings.ToLower(k), v)
-		}
+// header fields of the block being decoded, the ones of streams we gave up on are dropped
+func (c *ClientConn) onNewHeaderField(f hpack.HeaderField) {
+	if c.cur == nil {
+		return
 	}
-	if req.Payload != nil && len(req.Payload.Key) > 0 {
-		if req.Payload.Val[0] == ' ' {
-			c.writeHeader(strings.ToLower(req.Payload.Key), req.Payload.Val[1:])
-		} else {
-			c.writeHeader(strings.ToLower(req.Payload.Key), req.Payload.Val)
-		}
-	}
-	return c.hbuf.Bytes()
-}
-
-func (c *clientConn) writeHeader(name, val string) {
-	c.henc.WriteField(hpack.HeaderField{Name: name, Value: val})
-}
-
-func (c *clientConn) onNewHeaderField(f hpack.HeaderField) {
 	if f.Name == ":status" {
 		code, err := strconv.Atoi(f.Value)
 		if err != nil {
 			log.Print(err)
 		} else {
-			c.status = code
+			c.cur.status = code
 		}
 	}
-	c.nextRes.Add(f.Name, f.Value)
+	c.cur.header.Add(f.Name, f.Value)
 }
@@ -0,0 +1,151 @@
+package h2
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// frame-level requests for malformed messages: the fields are sent exactly as given (order, case,
+// duplicate pseudo-headers, connection-specific headers...), nothing is validated. each field is
+// written as an HPACK literal with a new name, so the dynamic table is never referenced and a
+// field can't break the encoding of the ones that follow
+
+// HPACK representation of a literal field
+type Indexing byte
+
+const (
+	IndexIncremental Indexing = iota // literal with incremental indexing (what most clients send)
+	IndexNone                        // literal without indexing
+	IndexNever                       // literal never indexed (intermediaries must keep the representation)
+)
+
+type Field struct {
+	Name     string
+	Value    string
+	Indexing Indexing
+	Huffman  bool // name and value are Huffman encoded
+}
+
+type RawRequest struct {
+	Fields []Field
+	Body   []byte
+
+	Timeout time.Duration // of the stream, the timeout of the connection if zero
+
+	HeaderSplit int   // size of the header block fragments, the rest goes in CONTINUATION frames (0: max frame size)
+	DataSplit   int   // size of the DATA frames (0: max frame size)
+	Padding     uint8 // padding of each DATA frame
+}
+
+// the fields a Request is sent with: pseudo-headers first, then the headers sorted by name (the
+// same order on every call, the recorded PoCs must match the wire), lowercase names, the first
+// space of the payload value is stripped
+func NewRawRequest(req *Request) *RawRequest {
+	r := &RawRequest{Body: req.Body, Timeout: req.Timeout}
+	r.Add(":authority", req.URL.Host)
+	r.Add(":method", req.Method)
+	r.Add(":path", req.URL.RequestURI())
+	r.Add(":scheme", req.URL.Scheme)
+	names := make([]string, 0, len(req.Hdrs))
+	for k := range req.Hdrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		for _, v := range req.Hdrs[k] {
+			r.Add(strings.ToLower(k), v)
+		}
+	}
+	if req.Payload != nil && len(req.Payload.Key) > 0 {
+		r.Add(strings.ToLower(req.Payload.Key), strings.TrimPrefix(req.Payload.Val, " "))
+	}
+	return r
+}
+
+// appends a field with incremental indexing, Huffman encoded when it's shorter
+func (r *RawRequest) Add(name, value string) *RawRequest {
+	return r.AddField(Field{
+		Name:    name,
+		Value:   value,
+		Huffman: hpack.HuffmanEncodeLength(name+value) < uint64(len(name+value)),
+	})
+}
+
+func (r *RawRequest) AddField(f Field) *RawRequest {
+	r.Fields = append(r.Fields, f)
+	return r
+}
+
+// value of the first field named name
+func (r *RawRequest) Get(name string) string {
+	for _, f := range r.Fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// the encoded header block
+func (r *RawRequest) HeaderBlock() []byte {
+	var res []byte
+	for _, f := range r.Fields {
+		res = appendField(res, f)
+	}
+	return res
+}
+
+// fields and body as sent, one "name: value" line per field
+func (r *RawRequest) String() string {
+	var sb strings.Builder
+	for _, f := range r.Fields {
+		sb.WriteString(f.Name + ": " + f.Value + "\r\n")
+	}
+	sb.WriteString("\r\n")
+	sb.Write(r.Body)
+	return sb.String()
+}
+
+// what the response is attached to
+func (r *RawRequest) httpRequest() *http.Request {
+	return &http.Request{Method: r.Get(":method"), ProtoMajor: 2, Header: make(http.Header)}
+}
+
+func appendField(dst []byte, f Field) []byte {
+	switch f.Indexing {
+	case IndexNone:
+		dst = appendInt(dst, 4, 0x00, 0)
+	case IndexNever:
+		dst = appendInt(dst, 4, 0x10, 0)
+	default:
+		dst = appendInt(dst, 6, 0x40, 0)
+	}
+	dst = appendString(dst, f.Name, f.Huffman)
+	return appendString(dst, f.Value, f.Huffman)
+}
+
+func appendString(dst []byte, s string, huffman bool) []byte {
+	if huffman {
+		dst = appendInt(dst, 7, 0x80, hpack.HuffmanEncodeLength(s))
+		return hpack.AppendHuffmanString(dst, s)
+	}
+	dst = appendInt(dst, 7, 0, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// HPACK integer with an n-bit prefix, first holds the bits before the prefix
+func appendInt(dst []byte, n uint, first byte, i uint64) []byte {
+	k := uint64(1)<<n - 1
+	if i < k {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(k))
+	for i -= k; i >= 128; i >>= 7 {
+		dst = append(dst, byte(0x80|i&0x7f))
+	}
+	return append(dst, byte(i))
+}
@@ -0,0 +1,108 @@
+package h2_test
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"smuggler/smuggler/h2"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+func decodeBlock(t *testing.T, block []byte) []hpack.HeaderField {
+	var res []hpack.HeaderField
+	dec := hpack.NewDecoder(4096, func(f hpack.HeaderField) { res = append(res, f) })
+	if _, err := dec.Write(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRawHeaderBlock(t *testing.T) {
+	long := strings.Repeat("a", 300) // multi-byte length
+	r := &h2.RawRequest{}
+	r.Add(":path", "/").
+		Add(":method", "POST").
+		Add(":method", "GET").
+		Add("Transfer-Encoding", "chunked").
+		AddField(h2.Field{Name: "connection", Value: "keep-alive", Indexing: h2.IndexNone}).
+		AddField(h2.Field{Name: "x-secret", Value: long, Indexing: h2.IndexNever, Huffman: true}).
+		AddField(h2.Field{Name: "x-crlf", Value: "a\r\nb: c", Indexing: h2.IndexNone})
+
+	got := decodeBlock(t, r.HeaderBlock())
+	want := []hpack.HeaderField{
+		{Name: ":path", Value: "/"},
+		{Name: ":method", Value: "POST"},
+		{Name: ":method", Value: "GET"},
+		{Name: "Transfer-Encoding", Value: "chunked"},
+		{Name: "connection", Value: "keep-alive"},
+		{Name: "x-secret", Value: long, Sensitive: true},
+		{Name: "x-crlf", Value: "a\r\nb: c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wanted: %+v, Got: %+v", want, got)
+	}
+
+	// the representation is the one asked for
+	plain := (&h2.RawRequest{}).AddField(h2.Field{Name: "a", Value: "b"}).HeaderBlock()
+	if want := []byte{0x40, 1, 'a', 1, 'b'}; !reflect.DeepEqual(plain, want) {
+		t.Errorf("Wanted: %x, Got: %x", want, plain)
+	}
+	huff := (&h2.RawRequest{}).AddField(h2.Field{Name: "aaaa", Value: "b", Indexing: h2.IndexNever, Huffman: true}).HeaderBlock()
+	if huff[0] != 0x10 || huff[1]&0x80 == 0 {
+		t.Errorf("Wanted a never indexed, Huffman encoded field, Got: %x", huff)
+	}
+}
+
+func TestNewRawRequest(t *testing.T) {
+	req := &h2.Request{
+		Method:  http.MethodPost,
+		URL:     &url.URL{Scheme: "https", Host: "example.com", Path: "/a", RawQuery: "b=c"},
+		Hdrs:    map[string][]string{"User-Agent": {"x"}, "Accept": {"*/*"}, "X-B": {"1", "2"}, "Cookie": {"a=b"}},
+		Payload: &h2.Payload{Key: "Content-Length", Val: " 5"},
+	}
+	want := []hpack.HeaderField{
+		{Name: ":authority", Value: "example.com"},
+		{Name: ":method", Value: "POST"},
+		{Name: ":path", Value: "/a?b=c"},
+		{Name: ":scheme", Value: "https"},
+		{Name: "accept", Value: "*/*"},
+		{Name: "cookie", Value: "a=b"},
+		{Name: "user-agent", Value: "x"},
+		{Name: "x-b", Value: "1"},
+		{Name: "x-b", Value: "2"},
+		{Name: "content-length", Value: "5"},
+	}
+	// the headers come from a map, the order must be the same on every call
+	for i := 0; i < 20; i++ {
+		if got := decodeBlock(t, h2.NewRawRequest(req).HeaderBlock()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Wanted: %+v, Got: %+v", want, got)
+		}
+	}
+}
+
+func TestRawFrames(t *testing.T) {
+	c, u := dialLab(t, "h2safe")
+	r := &h2.RawRequest{
+		Body:        []byte("x=12345"),
+		HeaderSplit: 8, // HEADERS followed by CONTINUATION frames
+		DataSplit:   2,
+		Padding:     16,
+	}
+	r.Add(":scheme", u.Scheme).Add(":path", "/").Add(":authority", u.Host).Add(":method", "POST").
+		Add("user-agent", strings.Repeat("x", 100))
+
+	resp, err := c.DoRaw(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.Method != "POST" {
+		t.Errorf("Wanted: %d for POST, Got: %d for %s", http.StatusOK, resp.StatusCode, resp.Request.Method)
+	}
+}
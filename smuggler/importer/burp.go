@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"smuggler/smuggler"
+	"strings"
+)
+
+// the parts of an item of a Burp XML export a target is built from
+type burpItem struct {
+	URL      string `xml:"url"`
+	Protocol string `xml:"protocol"`
+	Request  struct {
+		Base64 bool   `xml:"base64,attr"`
+		Data   string `xml:",chardata"`
+	} `xml:"request"`
+}
+
+// parses every request of a Burp XML export, in order. the request is sent to the URL of the
+// item (the host and port Burp connected to), not the one of its Host header
+func ParseBurp(r io.Reader) ([]smuggler.Target, error) {
+	var export struct {
+		Items []burpItem `xml:"item"`
+	}
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false // exports start with a DTD
+	if err := decoder.Decode(&export); err != nil {
+		return nil, fmt.Errorf("invalid Burp export: %w", err)
+	}
+
+	res := make([]smuggler.Target, 0, len(export.Items))
+	for i, item := range export.Items {
+		t, err := item.target()
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i+1, err)
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+func (item *burpItem) target() (smuggler.Target, error) {
+	raw := []byte(item.Request.Data)
+	if item.Request.Base64 {
+		var err error
+		if raw, err = base64.StdEncoding.DecodeString(strings.TrimSpace(item.Request.Data)); err != nil {
+			return smuggler.Target{}, fmt.Errorf("invalid request: %w", err)
+		}
+	}
+	t, err := ParseRaw(raw, item.Protocol)
+	if err != nil {
+		return t, err
+	}
+
+	if len(item.URL) > 0 {
+		u, err := url.Parse(strings.TrimSpace(item.URL))
+		if err != nil || !u.IsAbs() {
+			return t, fmt.Errorf("invalid url: %q", item.URL)
+		}
+		t.URL = u.String()
+	}
+	return t, nil
+}
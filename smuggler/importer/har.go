@@ -0,0 +1,94 @@
+package importer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"smuggler/smuggler"
+)
+
+// the parts of a HAR 1.2 archive a target is built from
+type harArchive struct {
+	Log struct {
+		Entries []struct {
+			Request harRequest `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harRequest struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Headers  []harField  `json:"headers"`
+	Cookies  []harField  `json:"cookies"`
+	PostData *harPayload `json:"postData"`
+}
+
+type harField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPayload struct {
+	Text     string     `json:"text"`
+	Encoding string     `json:"encoding"` // "base64" for binary bodies (not in the spec, written by some browsers)
+	Params   []harField `json:"params"`
+}
+
+// parses every request of a HAR archive, in order
+func ParseHAR(r io.Reader) ([]smuggler.Target, error) {
+	var har harArchive
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, fmt.Errorf("invalid HAR: %w", err)
+	}
+
+	res := make([]smuggler.Target, 0, len(har.Log.Entries))
+	for i, e := range har.Log.Entries {
+		t, err := e.Request.target()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+func (req *harRequest) target() (smuggler.Target, error) {
+	t := smuggler.Target{URL: req.URL, Method: req.Method}
+	if u, err := url.Parse(req.URL); err != nil || !u.IsAbs() {
+		return t, fmt.Errorf("invalid url: %q", req.URL)
+	}
+
+	for _, h := range req.Headers {
+		if err := addHeader(&t, h.Name, h.Value); err != nil {
+			return t, err
+		}
+	}
+	if len(t.Headers["Cookie"]) == 0 { // devtools don't always keep the Cookie header
+		for _, c := range req.Cookies {
+			addHeader(&t, "Cookie", c.Name+"="+c.Value)
+		}
+	}
+
+	if p := req.PostData; p != nil {
+		switch {
+		case p.Encoding == "base64":
+			b, err := base64.StdEncoding.DecodeString(p.Text)
+			if err != nil {
+				return t, fmt.Errorf("invalid body: %w", err)
+			}
+			t.Body = string(b)
+		case len(p.Text) > 0:
+			t.Body = p.Text
+		case len(p.Params) > 0:
+			v := url.Values{}
+			for _, f := range p.Params {
+				v.Add(f.Name, f.Value)
+			}
+			t.Body = v.Encode()
+		}
+	}
+	return t, nil
+}
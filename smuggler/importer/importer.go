@@ -0,0 +1,131 @@
+// Package importer turns captured requests into scan targets: raw HTTP requests (as saved by
+// Burp's "Copy to file" or typed by hand), HAR archives exported from browser devtools and Burp
+// XML exports ("Save items"). targets keep the method, path, headers, cookies and body of the
+// request, hop-by-hop and framing headers are dropped (the probes set their own).
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"smuggler/smuggler"
+	"strings"
+)
+
+type Format string
+
+const (
+	Raw  Format = "raw"
+	HAR  Format = "har"
+	Burp Format = "burp"
+)
+
+// headers of the connection or of the framing of the body, never copied to a target
+var dropped = map[string]bool{
+	"host":              true, // from the URL
+	"content-length":    true,
+	"transfer-encoding": true,
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"upgrade":           true,
+	"te":                true,
+	"http2-settings":    true,
+}
+
+// guesses the format of a capture from its first bytes
+func Detect(data []byte) Format {
+	data = bytes.TrimLeft(data, " \t\r\n\uFEFF")
+	switch {
+	case bytes.HasPrefix(data, []byte("{")):
+		return HAR
+	case bytes.HasPrefix(data, []byte("<")):
+		return Burp
+	}
+	return Raw
+}
+
+// reads the targets of a capture file, every file of a directory (raw requests saved one per
+// file, *.har or *.xml exports) is read. scheme is the scheme of raw requests whose request line
+// isn't an absolute URL
+func Load(path, scheme string) ([]smuggler.Target, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return loadFile(path, scheme)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var res []smuggler.Target
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		targets, err := loadFile(filepath.Join(path, e.Name()), scheme)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, targets...)
+	}
+	return res, nil
+}
+
+func loadFile(path, scheme string) ([]smuggler.Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var res []smuggler.Target
+	switch Detect(data) {
+	case HAR:
+		res, err = ParseHAR(bytes.NewReader(data))
+	case Burp:
+		res, err = ParseBurp(bytes.NewReader(data))
+	default:
+		var t smuggler.Target
+		if t, err = ParseRaw(data, scheme); err == nil {
+			res = []smuggler.Target{t}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%s: no requests", path)
+	}
+	return res, nil
+}
+
+// adds a header field to t with its canonical name, the Cookie header is split into its cookies (the form of
+// Target.Headers["Cookie"]) and pseudo-headers of h2 captures are skipped
+func addHeader(t *smuggler.Target, name, value string) error {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return errors.New("empty header name")
+	}
+	if strings.HasPrefix(name, ":") || dropped[strings.ToLower(name)] {
+		return nil
+	}
+	if t.Headers == nil {
+		t.Headers = make(map[string][]string)
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name) // h2 captures have lowercase names
+	if name != "Cookie" {
+		t.Headers[name] = append(t.Headers[name], value)
+		return nil
+	}
+	for _, c := range strings.Split(value, ";") {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			t.Headers["Cookie"] = append(t.Headers["Cookie"], c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package importer_test
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"smuggler/smuggler"
+	"smuggler/smuggler/importer"
+	"strings"
+	"testing"
+)
+
+const rawPOST = "POST /api/cart?id=1 HTTP/1.1\r\n" +
+	"Host: shop.example:8443\r\n" +
+	"Cookie: session=abc; theme=dark\r\n" +
+	"Content-Type: application/x-www-form-urlencoded\r\n" +
+	"Content-Length: 7\r\n" +
+	"Connection: keep-alive\r\n" +
+	"\r\n" +
+	"qty=1&x\n"
+
+var postTarget = smuggler.Target{
+	URL:    "https://shop.example:8443/api/cart?id=1",
+	Method: "POST",
+	Body:   "qty=1&x",
+	Headers: map[string][]string{
+		"Cookie":       {"session=abc", "theme=dark"},
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	},
+}
+
+func TestParseRaw(t *testing.T) {
+	table := []struct {
+		name   string
+		raw    string
+		scheme string
+		want   smuggler.Target
+	}{
+		{"crlf", rawPOST, "", postTarget},
+		{"lf", strings.ReplaceAll(rawPOST, "\r\n", "\n"), "https", postTarget},
+		{"absolute", "GET http://a.example/x HTTP/1.1\nHost: b.example\n", "https",
+			smuggler.Target{URL: "http://a.example/x", Method: "GET"}},
+		{"http2", "PUT /x HTTP/2\r\n:authority: a.example\r\nHost: a.example\r\nX-Token: 1\r\n\r\nbody\r\n", "http",
+			smuggler.Target{URL: "http://a.example/x", Method: "PUT", Body: "body", Headers: map[string][]string{"X-Token": {"1"}}}},
+		{"chunked", "POST / HTTP/1.1\r\nHost: a.example\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n", "https",
+			smuggler.Target{URL: "https://a.example/", Method: "POST", Body: "abcde"}},
+	}
+	for _, Case := range table {
+		t.Run(Case.name, func(t *testing.T) {
+			got, err := importer.ParseRaw([]byte(Case.raw), Case.scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, Case.want) {
+				t.Errorf("Wanted: %+v, Got: %+v", Case.want, got)
+			}
+		})
+	}
+
+	for _, raw := range []string{"", "GET /\r\n", "GET / HTTP/1.1\r\n\r\n", "GET / HTTP/1.1\r\nHost: a\r\nbad\r\n"} {
+		if _, err := importer.ParseRaw([]byte(raw), ""); err == nil {
+			t.Errorf("%q: Wanted an error", raw)
+		}
+	}
+}
+
+const harArchive = `{"log": {"version": "1.2", "entries": [
+	{"request": {"method": "POST", "url": "https://shop.example:8443/api/cart?id=1", "httpVersion": "HTTP/2",
+		"headers": [{"name": ":authority", "value": "shop.example:8443"}, {"name": "cookie", "value": "session=abc; theme=dark"},
+			{"name": "content-type", "value": "application/x-www-form-urlencoded"}, {"name": "content-length", "value": "7"}],
+		"postData": {"mimeType": "application/x-www-form-urlencoded", "text": "qty=1&x"}}},
+	{"request": {"method": "POST", "url": "http://a.example/", "headers": [],
+		"cookies": [{"name": "session", "value": "abc"}],
+		"postData": {"params": [{"name": "a", "value": "1 2"}]}}}
+]}}`
+
+func TestParseHAR(t *testing.T) {
+	got, err := importer.ParseHAR(strings.NewReader(harArchive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []smuggler.Target{
+		{URL: postTarget.URL, Method: "POST", Body: "qty=1&x", Headers: map[string][]string{
+			"Cookie": {"session=abc", "theme=dark"}, "Content-Type": {"application/x-www-form-urlencoded"}}},
+		{URL: "http://a.example/", Method: "POST", Body: "a=1+2", Headers: map[string][]string{"Cookie": {"session=abc"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wanted: %+v, Got: %+v", want, got)
+	}
+
+	if _, err := importer.ParseHAR(strings.NewReader(`{"log": {"entries": [{"request": {"url": "/x"}}]}}`)); err == nil {
+		t.Error("Wanted an error for a relative URL")
+	}
+}
+
+func burpExport() string {
+	return `<?xml version="1.0"?>
+<!DOCTYPE items [
+<!ELEMENT items (item*)>
+<!ATTLIST items burpVersion CDATA "">
+]>
+<items burpVersion="2024.1">
+  <item>
+    <url><![CDATA[https://shop.example:8443/api/cart?id=1]]></url>
+    <host ip="10.0.0.1">shop.example</host>
+    <port>8443</port>
+    <protocol>https</protocol>
+    <method><![CDATA[POST]]></method>
+    <request base64="true"><![CDATA[` + base64.StdEncoding.EncodeToString([]byte(rawPOST)) + `]]></request>
+  </item>
+  <item>
+    <url><![CDATA[http://b.example/]]></url>
+    <protocol>http</protocol>
+    <request base64="false"><![CDATA[GET / HTTP/1.1
+Host: spoofed.example
+
+]]></request>
+  </item>
+</items>`
+}
+
+func TestParseBurp(t *testing.T) {
+	got, err := importer.ParseBurp(strings.NewReader(burpExport()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []smuggler.Target{postTarget, {URL: "http://b.example/", Method: "GET"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wanted: %+v, Got: %+v", want, got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": rawPOST, "b.har": harArchive, "c.xml": burpExport(), ".hidden": "junk"}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := importer.Detect([]byte("\n " + harArchive)); got != importer.HAR {
+		t.Errorf("Wanted: %s, Got: %s", importer.HAR, got)
+	}
+
+	targets, err := importer.Load(dir, "https")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 5 {
+		t.Errorf("Wanted: 5 targets, Got: %+v", targets)
+	}
+
+	targets, err = importer.Load(filepath.Join(dir, "a.txt"), "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0].URL != "http://shop.example:8443/api/cart?id=1" {
+		t.Errorf("Wanted: the raw request over http, Got: %+v", targets)
+	}
+
+	os.WriteFile(filepath.Join(dir, "d.har"), []byte(`{"log": {"entries": []}}`), 0644)
+	if _, err := importer.Load(dir, "https"); err == nil {
+		t.Error("Wanted an error for an empty capture")
+	}
+}
@@ -0,0 +1,98 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"net/url"
+	"smuggler/smuggler"
+	"strconv"
+	"strings"
+)
+
+// parses a raw HTTP/1.x request (or an h2 request written as one, "HTTP/2" on the request line).
+// the URL is the request target if it is absolute, else scheme://Host/target. lines may end in
+// CRLF or LF, a body longer than its Content-Length is cut (editors add a newline at the end of
+// a file) and a chunked body is decoded
+func ParseRaw(data []byte, scheme string) (smuggler.Target, error) {
+	var t smuggler.Target
+	if len(scheme) == 0 {
+		scheme = "https"
+	}
+	data = bytes.TrimLeft(data, "\r\n\uFEFF")
+
+	head, body := data, []byte(nil)
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		head, body = data[:i], data[i+2:]
+	}
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 && i < len(head) {
+		head, body = data[:i], data[i+4:]
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(head), "\r\n", "\n"), "\n")
+	parts := strings.Fields(lines[0])
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		return t, fmt.Errorf("invalid request line: %q", lines[0])
+	}
+	t.Method = parts[0]
+
+	var host, cl string
+	chunked := false
+	for _, line := range lines[1:] {
+		if len(line) == 0 || strings.HasPrefix(line, ":") { // pseudo-headers of an h2 request
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return t, fmt.Errorf("invalid header line: %q", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "host":
+			host = value
+		case "content-length":
+			cl = value
+		case "transfer-encoding":
+			chunked = strings.EqualFold(value, "chunked")
+		}
+		if err := addHeader(&t, name, value); err != nil {
+			return t, err
+		}
+	}
+
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return t, err
+	}
+	if !u.IsAbs() {
+		if len(host) == 0 {
+			return t, errors.New("missing Host header")
+		}
+		if u, err = url.Parse(scheme + "://" + host + parts[1]); err != nil {
+			return t, err
+		}
+	}
+	t.URL = u.String()
+
+	switch {
+	case chunked:
+		if body, err = io.ReadAll(httputil.NewChunkedReader(bufio.NewReader(bytes.NewReader(body)))); err != nil {
+			return t, fmt.Errorf("invalid chunked body: %w", err)
+		}
+	case len(cl) > 0:
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return t, fmt.Errorf("invalid Content-Length: %q", cl)
+		}
+		if n < len(body) {
+			body = body[:n]
+		}
+	default:
+		body = bytes.TrimRight(body, "\r\n")
+	}
+	t.Body = string(body)
+	return t, nil
+}
@@ -0,0 +1,234 @@
+package lab
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// a bare-bones HTTP/2 front-end. x/net/http2's server validates header fields and
+// content-length, which is exactly what a vulnerable front-end must not do.
+type h2conn struct {
+	lab  *Lab
+	conn net.Conn
+
+	framer *http2.Framer
+	hdec   *hpack.Decoder
+
+	muW  sync.Mutex // guards framer writes and the encoder
+	hbuf bytes.Buffer
+	henc *hpack.Encoder
+
+	streams map[uint32]*h2stream
+}
+
+type h2stream struct {
+	id         uint32
+	fields     []hpack.HeaderField
+	body       bytes.Buffer
+	endHeaders bool
+	endStream  bool
+}
+
+func (l *Lab) serveH2(conn net.Conn) {
+	preface := make([]byte, len(http2.ClientPreface))
+	conn.SetReadDeadline(time.Now().Add(l.timeout))
+	if _, err := io.ReadFull(conn, preface); err != nil || string(preface) != http2.ClientPreface {
+		return
+	}
+
+	sc := &h2conn{
+		lab:     l,
+		conn:    conn,
+		framer:  http2.NewFramer(conn, conn),
+		hdec:    hpack.NewDecoder(4096, nil),
+		streams: make(map[uint32]*h2stream),
+	}
+	sc.henc = hpack.NewEncoder(&sc.hbuf)
+
+	sc.muW.Lock()
+	err := sc.framer.WriteSettings(http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: 100})
+	sc.muW.Unlock()
+	if err != nil {
+		return
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+		fr, err := sc.framer.ReadFrame()
+		if err != nil {
+			return
+		}
+
+		var st *h2stream
+		switch f := fr.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				sc.muW.Lock()
+				err = sc.framer.WriteSettingsAck()
+				sc.muW.Unlock()
+			}
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				sc.muW.Lock()
+				err = sc.framer.WritePing(true, f.Data)
+				sc.muW.Unlock()
+			}
+		case *http2.HeadersFrame:
+			st = &h2stream{id: f.StreamID}
+			sc.streams[st.id] = st
+			sc.decode(st, f.HeaderBlockFragment())
+			st.endHeaders = f.HeadersEnded()
+			st.endStream = f.StreamEnded()
+		case *http2.ContinuationFrame:
+			if st = sc.streams[f.StreamID]; st != nil {
+				sc.decode(st, f.HeaderBlockFragment())
+				st.endHeaders = f.HeadersEnded()
+			}
+		case *http2.DataFrame:
+			if st = sc.streams[f.StreamID]; st != nil {
+				st.body.Write(f.Data())
+				st.endStream = f.StreamEnded()
+				if n := uint32(len(f.Data())); n > 0 {
+					sc.muW.Lock()
+					sc.framer.WriteWindowUpdate(0, n)
+					if !st.endStream {
+						sc.framer.WriteWindowUpdate(st.id, n)
+					}
+					sc.muW.Unlock()
+				}
+			}
+		case *http2.RSTStreamFrame:
+			delete(sc.streams, f.StreamID)
+		case *http2.GoAwayFrame:
+			return
+		}
+		if err != nil {
+			return
+		}
+		if st != nil && st.endHeaders && st.endStream {
+			delete(sc.streams, st.id)
+			go sc.handle(st)
+		}
+	}
+}
+
+func (sc *h2conn) decode(st *h2stream, frag []byte) {
+	sc.hdec.SetEmitFunc(func(f hpack.HeaderField) {
+		st.fields = append(st.fields, f)
+	})
+	sc.hdec.Write(frag)
+}
+
+func (sc *h2conn) handle(st *h2stream) {
+	raw, method, err := sc.lab.downgrade(st.fields, st.body.Bytes())
+	if err != nil {
+		sc.muW.Lock()
+		sc.framer.WriteRSTStream(st.id, http2.ErrCodeProtocol)
+		sc.muW.Unlock()
+		return
+	}
+	resp, err := sc.lab.forward(raw, method)
+	if err != nil {
+		sc.respond(st.id, http.StatusBadGateway, nil)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	sc.respond(st.id, resp.StatusCode, body)
+}
+
+func (sc *h2conn) respond(id uint32, code int, body []byte) {
+	sc.muW.Lock()
+	defer sc.muW.Unlock()
+
+	sc.hbuf.Reset()
+	sc.henc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(code)})
+	sc.henc.WriteField(hpack.HeaderField{Name: "content-type", Value: "text/html"})
+	sc.henc.WriteField(hpack.HeaderField{Name: "content-length", Value: strconv.Itoa(len(body))})
+	if err := sc.framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: sc.hbuf.Bytes(),
+		EndHeaders:    true,
+		EndStream:     len(body) == 0,
+	}); err != nil {
+		return
+	}
+	for len(body) > 0 {
+		chunk := body
+		if len(chunk) > 1<<14 {
+			chunk = chunk[:1<<14]
+		}
+		body = body[len(chunk):]
+		if err := sc.framer.WriteData(id, len(body) == 0, chunk); err != nil {
+			return
+		}
+	}
+}
+
+var connHeaders = []string{"content-length", "transfer-encoding", "connection", "keep-alive", "upgrade", "proxy-connection"}
+
+// rebuilds an HTTP/1.1 request from the h2 one
+func (l *Lab) downgrade(fields []hpack.HeaderField, body []byte) ([]byte, string, error) {
+	var method, path, authority string
+	var hdrs bytes.Buffer
+	hasCL := false
+
+	for _, f := range fields {
+		if !l.Profile.Passthrough && strings.ContainsAny(f.Name+f.Value, "\r\n\x00") {
+			return nil, "", errors.New("invalid header field")
+		}
+		switch f.Name {
+		case ":method":
+			method = f.Value
+		case ":path":
+			path = f.Value
+		case ":authority":
+			authority = f.Value
+		case ":scheme":
+		default:
+			if strings.HasPrefix(f.Name, ":") {
+				return nil, "", fmt.Errorf("unknown pseudo-header: %s", f.Name)
+			}
+			name := strings.ToLower(f.Name)
+			if !l.Profile.Passthrough && (name == "host" || contains(connHeaders, name)) {
+				continue
+			}
+			if name == "content-length" {
+				hasCL = true
+			}
+			fmt.Fprintf(&hdrs, "%s: %s\r\n", f.Name, f.Value)
+		}
+	}
+	if len(method) == 0 || len(path) == 0 {
+		return nil, "", errors.New("missing pseudo-header")
+	}
+
+	var sb bytes.Buffer
+	fmt.Fprintf(&sb, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, path, authority)
+	sb.Write(hdrs.Bytes())
+	if !hasCL && (len(body) > 0 || method == http.MethodPost || method == http.MethodPut) {
+		fmt.Fprintf(&sb, "Content-Length: %d\r\n", len(body))
+	}
+	sb.WriteString("\r\n")
+	sb.Write(body)
+	return sb.Bytes(), method, nil
+}
+
+func contains(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
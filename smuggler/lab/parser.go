@@ -0,0 +1,222 @@
+package lab
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TEMode is how a tier treats the Transfer-Encoding header
+type TEMode byte
+
+const (
+	TEIgnore  TEMode = iota // chunked encoding isn't supported, Content-Length is always used
+	TEStrict                // only a well-formed "Transfer-Encoding: chunked" is honoured
+	TELenient               // obfuscated names/values (whitespace, control bytes, case, '_') are honoured too
+)
+
+var teModeName = map[TEMode]string{
+	TEIgnore:  "ignore",
+	TEStrict:  "strict",
+	TELenient: "lenient",
+}
+
+func (m TEMode) String() string {
+	if res, ok := teModeName[m]; ok {
+		return res
+	}
+	return fmt.Sprintf("unknown te mode: %d", m)
+}
+
+func ParseTEMode(s string) (TEMode, error) {
+	for k, v := range teModeName {
+		if strings.EqualFold(v, s) {
+			return k, nil
+		}
+	}
+	return TEIgnore, fmt.Errorf("invalid te mode: %s: valid modes: ignore,strict,lenient", s)
+}
+
+// Tier is the set of HTTP/1.1 parsing quirks of one server in the chain
+type Tier struct {
+	TE       TEMode
+	IgnoreCL bool // CL.0: the body is never read, it becomes the start of the next request
+}
+
+type request struct {
+	method string
+	path   string
+	head   []byte // request line + headers + empty line, exactly as received
+	body   []byte // body as framed by the tier (raw chunks if chunked)
+}
+
+// raw bytes that would be forwarded to the next hop
+func (r *request) raw() []byte {
+	return append(append([]byte{}, r.head...), r.body...)
+}
+
+var errBadRequest = errors.New("bad request")
+
+const maxHeadSize = 1 << 16
+
+func (t Tier) readRequest(br *bufio.Reader) (*request, error) {
+	var head bytes.Buffer
+	var lines [][]byte
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 && len(bytes.TrimRight(line, "\r\n")) == 0 {
+			continue // leading empty lines are ignored (RFC 9112 2.2)
+		}
+		head.Write(line)
+		if head.Len() > maxHeadSize {
+			return nil, errBadRequest
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(line) == 0 {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	parts := strings.Split(string(lines[0]), " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return nil, errBadRequest
+	}
+	req := &request{method: parts[0], path: parts[1], head: head.Bytes()}
+
+	chunked, cl, err := t.framing(lines[1:])
+	if err != nil {
+		return nil, err
+	}
+	if chunked {
+		req.body, err = readChunked(br)
+		if err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+	if cl > 0 {
+		req.body = make([]byte, cl)
+		if _, err := io.ReadFull(br, req.body); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// decides how the body of a request is delimited
+func (t Tier) framing(lines [][]byte) (bool, int, error) {
+	chunked := false
+	cl := -1
+
+	for _, l := range lines {
+		fields := [][]byte{l}
+		if t.TE == TELenient {
+			fields = bytes.Split(l, []byte("\r")) // bare CR is a line break for lenient parsers
+		}
+		for _, f := range fields {
+			idx := bytes.IndexByte(f, ':')
+			if idx < 0 {
+				continue
+			}
+			name, val := string(f[:idx]), string(f[idx+1:])
+			if t.isTE(name, val) {
+				chunked = true
+				continue
+			}
+			if !t.isCL(name) {
+				continue
+			}
+			n, err := strconv.Atoi(strings.Trim(val, " \t"))
+			if err != nil || n < 0 {
+				return false, 0, errBadRequest
+			}
+			if cl >= 0 && cl != n && t.TE != TELenient {
+				return false, 0, errBadRequest // conflicting Content-Length headers
+			}
+			if cl < 0 {
+				cl = n
+			}
+		}
+	}
+	if t.IgnoreCL || cl < 0 {
+		cl = 0
+	}
+	return chunked, cl, nil
+}
+
+func (t Tier) isTE(name, val string) bool {
+	switch t.TE {
+	case TEStrict:
+		return strings.EqualFold(name, "Transfer-Encoding") &&
+			strings.EqualFold(strings.Trim(val, " \t"), "chunked")
+	case TELenient:
+		return normalize(name) == "transfer-encoding" && strings.Contains(strings.ToLower(val), "chunked")
+	}
+	return false
+}
+
+func (t Tier) isCL(name string) bool {
+	if t.TE == TELenient {
+		return normalize(name) == "content-length"
+	}
+	return strings.EqualFold(name, "Content-Length")
+}
+
+// strips whitespace and control bytes around a header name, lowercases it and treats ' '/'_' as '-'
+func normalize(name string) string {
+	name = strings.TrimFunc(name, func(r rune) bool {
+		return r <= 0x20 || r >= 0x7f
+	})
+	name = strings.NewReplacer(" ", "-", "_", "-").Replace(name)
+	return strings.ToLower(name)
+}
+
+// reads a chunked body, returning the raw bytes that were consumed
+func readChunked(br *bufio.Reader) ([]byte, error) {
+	var raw bytes.Buffer
+
+	for {
+		line, err := br.ReadBytes('\n')
+		raw.Write(line)
+		if err != nil {
+			return nil, err
+		}
+		size := strings.TrimRight(string(line), "\r\n")
+		if i := strings.IndexByte(size, ';'); i >= 0 {
+			size = size[:i] // chunk extension
+		}
+		n, err := strconv.ParseInt(strings.Trim(size, " \t"), 16, 32)
+		if err != nil || n < 0 {
+			return nil, errBadRequest
+		}
+		if n == 0 {
+			for { // trailer section
+				line, err := br.ReadBytes('\n')
+				raw.Write(line)
+				if err != nil {
+					return nil, err
+				}
+				if len(bytes.TrimRight(line, "\r\n")) == 0 {
+					return raw.Bytes(), nil
+				}
+			}
+		}
+		chunk := make([]byte, n+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(chunk, []byte("\r\n")) {
+			return nil, errBadRequest
+		}
+		raw.Write(chunk)
+	}
+}
@@ -0,0 +1,437 @@
+package smuggler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/h2"
+	"smuggler/smuggler/lab"
+	"smuggler/smuggler/tests"
+	"smuggler/smuggler/throttle"
+	"strings"
+	"testing"
+	"time"
+)
+
+// detectors are run end to end against the local lab, each technique must fire on
+// the chain it targets and stay quiet on a chain that agrees on framing
+
+type labTest struct {
+	profile string
+	want    bool
+}
+
+func startLab(t *testing.T, name string) *DesyncerImpl {
+	if testing.Short() {
+		t.Skip("timing based detection is slow")
+	}
+	l, err := lab.Start(lab.Profiles[name], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil { // reports are written to ./result
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(pwd) })
+
+	d := &DesyncerImpl{
+		Method: "POST",
+		Hdr:    make(map[string][]string),
+		Opts:   &Options{Timeout: time.Second * 2},
+		Ctx:    context.Background(),
+	}
+	if err := d.ParseURL(l.URL()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func checkFinding(t *testing.T, d *DesyncerImpl, want Technique) {
+	f := d.Findings()
+	if len(f) != 1 {
+		t.Errorf("Wanted: 1 finding, Got: %d", len(f))
+		return
+	}
+	if f[0].Technique != want {
+		t.Errorf("Wanted: %s, Got: %s", want, f[0].Technique)
+	}
+	if len(f[0].Probes) != 2 || f[0].Probes[0].Code != ProbeTimeout || f[0].Probes[1].Status != 200 {
+		t.Errorf("unexpected probes: %+v", f[0].Probes)
+	}
+	// the attack, then the victim on another connection
+	if poc := f[0].PoC; len(poc) != 2 || poc[0].Conn == poc[1].Conn || len(poc[0].Raw)+len(poc[0].Fields) == 0 {
+		t.Errorf("unexpected PoC: %+v", poc)
+	}
+}
+
+func TestLabCLTE(t *testing.T) {
+	for _, Case := range []labTest{{"clte", true}, {"safe", false}, {"tecl", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			cl := CL{DesyncerImpl: startLab(t, Case.profile)}
+			if got := cl.clte(tests.Plain(tests.TE)); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if !Case.want {
+				return
+			}
+			checkFinding(t, cl.DesyncerImpl, CLTE)
+			// the reported attack smuggles a harmless request
+			if f := cl.Findings(); len(f) == 1 && (!strings.HasSuffix(f[0].Request, smuggledPrefix("/hopefully404")) || f[0].PoC[0].Raw != f[0].Request) {
+				t.Errorf("unexpected reported request: %q", f[0].Request)
+			}
+		})
+	}
+}
+
+func TestLabTECL(t *testing.T) {
+	for _, Case := range []labTest{{"tecl", true}, {"safe", false}, {"clte", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			te := TE{DesyncerImpl: startLab(t, Case.profile)}
+			if got := te.tecl(tests.Plain(tests.TE)); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if Case.want {
+				checkFinding(t, te.DesyncerImpl, TECL)
+			}
+		})
+	}
+}
+
+func TestLabTETE(t *testing.T) {
+	for _, Case := range []labTest{{"tete", true}, {"safe", false}, {"clte", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			te := TE{DesyncerImpl: startLab(t, Case.profile)}
+			m := tests.Mutation{ID: "TE-B-002", Type: tests.TE, Key: " Transfer-Encoding", Val: " chunked"} // only the lenient front-end honours the space
+			if got := te._TETE(m); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if !Case.want {
+				return
+			}
+			// the duplicated header times out, the control with a single one is rejected
+			f := te.Findings()
+			if len(f) != 1 || f[0].Technique != TETE {
+				t.Fatalf("Wanted: 1 %s finding, Got: %+v", TETE, f)
+			}
+			if p := f[0].Probes; len(p) != 2 || p[0].Code != ProbeTimeout || p[1].Status != 400 {
+				t.Errorf("unexpected probes: %+v", p)
+			}
+		})
+	}
+}
+
+func TestLabH2TE(t *testing.T) {
+	for _, Case := range []labTest{{"h2te", true}, {"h2safe", false}, {"h2cl", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			h := H2{DesyncerImpl: startLab(t, Case.profile)}
+			if got := h.runTest(tests.Plain(tests.TE)); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if Case.want {
+				checkFinding(t, h.DesyncerImpl, H2TE)
+			}
+		})
+	}
+}
+
+func TestLabH2CL(t *testing.T) {
+	for _, Case := range []labTest{{"h2cl", true}, {"h2safe", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			h := H2{DesyncerImpl: startLab(t, Case.profile)}
+			if got := h.runTest(tests.Plain(tests.CL)); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if Case.want {
+				checkFinding(t, h.DesyncerImpl, H2CL)
+			}
+		})
+	}
+}
+
+func TestLabH20(t *testing.T) {
+	for _, Case := range []labTest{{"h20", true}, {"h2safe", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			h := H2{DesyncerImpl: startLab(t, Case.profile)}
+			if got := h.runH20(); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if !Case.want {
+				return
+			}
+			f := h.Findings()
+			if len(f) != 1 || f[0].Technique != H20 || !f[0].Confirmed {
+				t.Fatalf("Wanted: 1 confirmed %s finding, Got: %+v", H20, f)
+			}
+			if f[0].Probes[1].Status != 404 || !strings.Contains(f[0].Request, "GET /hopefully404-") {
+				t.Errorf("unexpected finding: %+v", f[0])
+			}
+		})
+	}
+}
+
+func TestLabCL0(t *testing.T) {
+	for _, Case := range []labTest{{"cl0", true}, {"safe", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			cl := CL{DesyncerImpl: startLab(t, Case.profile)}
+			cl.Opts.ExitEarly = true
+			if got := cl.runCL0(); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			if !Case.want {
+				return
+			}
+			f := cl.Findings()
+			if len(f) != 1 || f[0].Technique != CL0 {
+				t.Errorf("Wanted: 1 %s finding, Got: %+v", CL0, f)
+				return
+			}
+			if f[0].Probes[1].Status != 404 || !strings.HasPrefix(f[0].Header, "Content-Length: ") {
+				t.Errorf("unexpected finding: %+v", f[0])
+			}
+		})
+	}
+}
+
+func TestLabConfirm(t *testing.T) {
+	for _, Case := range []struct {
+		profile string
+		run     func(d *DesyncerImpl) bool
+		want    Technique
+	}{
+		{"clte", func(d *DesyncerImpl) bool {
+			cl := CL{DesyncerImpl: d}
+			return cl.clte(tests.Plain(tests.TE))
+		}, CLTE},
+		{"tecl", func(d *DesyncerImpl) bool {
+			te := TE{DesyncerImpl: d}
+			return te.tecl(tests.Plain(tests.TE))
+		}, TECL},
+		{"h2cl", func(d *DesyncerImpl) bool {
+			h := H2{DesyncerImpl: d}
+			return h.runTest(tests.Plain(tests.CL))
+		}, H2CL},
+	} {
+		t.Run(Case.profile, func(t *testing.T) {
+			d := startLab(t, Case.profile)
+			d.Opts.Confirm = true
+			if !Case.run(d) {
+				t.Fatal("Wanted a confirmed finding")
+			}
+			checkFinding(t, d, Case.want)
+			f := d.Findings()[0]
+			if !f.Confirmed || len(f.Evidence) != 2 {
+				t.Fatalf("Wanted attack and victim evidence, Got: %+v", f.Evidence)
+			}
+			if victim := f.Evidence[1]; victim.Status != 404 || !strings.Contains(victim.Response, "404 Not Found") {
+				t.Errorf("Wanted the smuggled 404 as the victim response, Got: %d %q", victim.Status, victim.Response)
+			}
+		})
+	}
+}
+
+func TestLabTunnel(t *testing.T) {
+	for _, Case := range []labTest{{"h2tunnel", true}, {"h2safe", false}} {
+		t.Run(Case.profile, func(t *testing.T) {
+			tn := Tunnel{DesyncerImpl: startLab(t, Case.profile)}
+			tn.Opts.ExitEarly = true
+			tn.Hdr["cookie"] = []string{"a=1"}
+			tn.Opts.Headers = map[string][]string{"Cookie": {"b=2"}, "X-A": {"1"}}
+			if got := tn.Run(); got != Case.want {
+				t.Errorf("Wanted: %v, Got: %v", Case.want, got)
+			}
+			// global headers the target sets are replaced, not sent twice
+			if want := map[string][]string{"cookie": {"a=1"}, "X-A": {"1"}}; !reflect.DeepEqual(tn.hdr, want) {
+				t.Errorf("Wanted: %v, Got: %v", want, tn.hdr)
+			}
+			if !Case.want {
+				return
+			}
+			found := tn.Findings()
+			if len(found) != 1 || found[0].Technique != H2Tunnel {
+				t.Fatalf("Wanted: 1 %s finding, Got: %+v", H2Tunnel, found)
+			}
+			f := found[0]
+			if !f.Confirmed || len(f.Evidence) != 1 || !strings.Contains(f.Evidence[0].Response, "HTTP/1.1 404") {
+				t.Errorf("Wanted the tunnelled 404 nested in the HEAD response, Got: %+v", f.Evidence)
+			}
+			if !strings.HasPrefix(f.Header, "method: ") || len(f.Probes) != 2 {
+				t.Errorf("unexpected finding: %+v", f)
+			}
+		})
+	}
+}
+
+func TestLabCalibrate(t *testing.T) {
+	cl := CL{DesyncerImpl: startLab(t, "clte")}
+	cl.H1Supported = true
+	cl.calibrate()
+	if cl.h1cal == nil || cl.h1cal.Timeout != minTimeout {
+		t.Fatalf("Wanted: a timeout of %v on loopback, Got: %+v", minTimeout, cl.h1cal)
+	}
+	if cl.h2cal != nil {
+		t.Errorf("Wanted: h2 not calibrated, Got: %+v", cl.h2cal)
+	}
+
+	// the hit is found with the calibrated timeout, not -T
+	if !cl.clte(tests.Plain(tests.TE)) {
+		t.Fatal("Wanted: a CL.TE finding")
+	}
+	checkFinding(t, cl.DesyncerImpl, CLTE)
+	if d := cl.Findings()[0].Probes[0].Duration; d >= cl.Opts.Timeout {
+		t.Errorf("Wanted: a timeout under %v, Got: %v", cl.Opts.Timeout, d)
+	}
+}
+
+func TestLabConfidence(t *testing.T) {
+	te := TE{DesyncerImpl: startLab(t, "tecl")}
+	if !te.tecl(tests.Plain(tests.TE)) {
+		t.Fatal("Wanted: a TE.CL finding")
+	}
+	f := te.Findings()[0]
+	if len(f.Signals) != 4 || len(f.Controls) != 1 || f.Controls[0].Code != ProbeNormal {
+		t.Errorf("unexpected signals: %+v, controls: %+v", f.Signals, f.Controls)
+	}
+	if !f.Disruptive {
+		t.Error("Wanted: a TE.CL finding labelled disruptive")
+	}
+	if f.Confidence <= pairConfidence(3) {
+		t.Errorf("Wanted: a confidence above %v with a normal control, Got: %v", pairConfidence(3), f.Confidence)
+	}
+
+	// under the threshold, nothing is reported
+	te = TE{DesyncerImpl: startLab(t, "tecl")}
+	te.Opts.MinConfidence = 0.99
+	if te.tecl(tests.Plain(tests.TE)) || len(te.Findings()) > 0 {
+		t.Errorf("Wanted: no finding under the threshold, Got: %+v", te.Findings())
+	}
+}
+
+func TestLabThrottle(t *testing.T) {
+	d := startLab(t, "safe")
+	d.Opts.Throttle = throttle.New(throttle.Config{Rate: 10})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if ret, err := d.H1Test(d.victimPl()); err != nil || ret.Code != ProbeNormal {
+			t.Fatalf("unexpected probe: %+v, err: %v", ret, err)
+		}
+	}
+	if d := time.Since(start); d < time.Millisecond*200 {
+		t.Errorf("Wanted: 3 requests in at least 200ms, Got: %v", d)
+	}
+}
+
+// a finding is replayed from its JSON: it reproduces on the chain it was found on, and not once
+// the chain is fixed (the target is redirected to a lab that agrees on framing)
+func TestLabReplay(t *testing.T) {
+	for _, Case := range []struct {
+		profile, fixed string
+		run            func(d *DesyncerImpl) bool
+	}{
+		{"clte", "safe", func(d *DesyncerImpl) bool {
+			d.Opts.Confirm = true
+			return (&CL{DesyncerImpl: d}).clte(tests.Plain(tests.TE))
+		}},
+		{"cl0", "safe", func(d *DesyncerImpl) bool { return (&CL{DesyncerImpl: d}).runCL0() }},
+		{"h2cl", "h2safe", func(d *DesyncerImpl) bool { return (&H2{DesyncerImpl: d}).runTest(tests.Plain(tests.CL)) }},
+		{"h20", "h2safe", func(d *DesyncerImpl) bool { return (&H2{DesyncerImpl: d}).runH20() }},
+	} {
+		t.Run(Case.profile, func(t *testing.T) {
+			d := startLab(t, Case.profile)
+			d.Opts.ExitEarly = true
+			if !Case.run(d) {
+				t.Fatal("Wanted a finding")
+			}
+			b, err := json.Marshal(d.Findings()[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var f Finding
+			if err := json.Unmarshal(b, &f); err != nil {
+				t.Fatal(err)
+			}
+			if f.Timeout != d.Opts.Timeout {
+				t.Errorf("Wanted the timeout of the scan, Got: %v", f.Timeout)
+			}
+
+			res, err := Replay(context.Background(), f, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Reproduced || len(res.Probes) == 0 {
+				t.Fatalf("Wanted the finding to reproduce, Got: %+v", res)
+			}
+			if res.Probes[0].Request == f.Probes[0].Request && f.Probes[0].H2 == nil {
+				t.Error("Wanted a new cache buster")
+			}
+
+			fixed, err := lab.Start(lab.Profiles[Case.fixed], "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fixed.Close()
+			u, _ := url.Parse(fixed.URL())
+			r := &dialer.Resolver{Hosts: map[string]string{targetAddr(d.URL): u.Host}}
+			if res, err = Replay(context.Background(), f, Options{Dialer: r}); err != nil {
+				t.Fatal(err)
+			}
+			if res.Reproduced {
+				t.Errorf("Wanted the finding not to reproduce on %s, Got: %s", Case.fixed, res.Reason)
+			}
+		})
+	}
+}
+
+// a non-tunnelling front-end answers the injected method like any request, with a body
+func TestTunnelMethodBody(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	d := &DesyncerImpl{Method: "GET", Hdr: make(map[string][]string), Opts: &Options{Timeout: time.Second * 2}, Ctx: context.Background()}
+	if err := d.ParseURL(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	tn := Tunnel{DesyncerImpl: d}
+	u := *d.URL
+	base := &Probe{Code: ProbeNormal, Status: 200}
+	if tn.tunnelled(tName{name: "method"}, &h2.Request{Method: "GET", URL: &u}, base) {
+		t.Errorf("Wanted: no finding for a response body to a GET, Got: %+v", tn.Findings())
+	}
+}
+
+// the tunnel probes wait for the timeout of the target, not the default of the transport
+func TestTunnelTimeout(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second * 10):
+		}
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	d := &DesyncerImpl{Method: "GET", Hdr: make(map[string][]string), Opts: &Options{Timeout: time.Second}, Ctx: context.Background()}
+	if err := d.ParseURL(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	tn := Tunnel{DesyncerImpl: d}
+	ret, _, _ := tn.send(&h2.Request{Method: "HEAD", URL: d.URL})
+	if ret.Code != ProbeTimeout || ret.Duration > time.Second*3 {
+		t.Errorf("Wanted: a timeout after %v, Got: %s after %v", d.Opts.Timeout, ret.Outcome, ret.Duration)
+	}
+}
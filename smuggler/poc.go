@@ -0,0 +1,53 @@
+package smuggler
+
+import (
+	"smuggler/smuggler/h1"
+	"smuggler/smuggler/h2"
+)
+
+// the requests of a finding's proof of concept, kept as they are sent (not escaped) so the
+// attack can be replayed byte for byte, see the poc package for the exporters
+
+const (
+	ProtoH1 = "HTTP/1.1"
+	ProtoH2 = "HTTP/2"
+)
+
+type PoCRequest struct {
+	Conn   int        `json:"conn"`             // requests with the same index are sent in order on one connection
+	Proto  string     `json:"proto"`            // ProtoH1 or ProtoH2
+	Raw    string     `json:"raw,omitempty"`    // HTTP/1.1 request, with its body
+	Fields []PoCField `json:"fields,omitempty"` // HTTP/2 header fields in the order they are sent, pseudo-headers first
+	Body   string     `json:"body,omitempty"`   // HTTP/2 DATA
+}
+
+type PoCField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func h1PoC(conn int, p *h1.Payload) PoCRequest {
+	return PoCRequest{Conn: conn, Proto: ProtoH1, Raw: p.ToString()}
+}
+
+func h2PoC(conn int, req *h2.Request) PoCRequest {
+	raw := h2.NewRawRequest(req)
+	res := PoCRequest{Conn: conn, Proto: ProtoH2, Body: string(raw.Body)}
+	for _, f := range raw.Fields {
+		res.Fields = append(res.Fields, PoCField{Name: f.Name, Value: f.Value})
+	}
+	return res
+}
+
+// an h1 attack followed by a victim request on a separate connection
+func (d *DesyncerImpl) h1PoC(attack *h1.Payload) []PoCRequest {
+	return []PoCRequest{h1PoC(0, attack), h1PoC(1, d.victimPl())}
+}
+
+// an h2 attack followed by a victim request on a separate connection (the front-end reuses the
+// poisoned back-end connection for other clients)
+func (h *H2) h2PoC(attack *h2.Request) []PoCRequest {
+	victim := h.newRequest("", "")
+	victim.Method = "GET"
+	return []PoCRequest{h2PoC(0, attack), h2PoC(1, victim)}
+}
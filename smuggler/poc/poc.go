@@ -0,0 +1,187 @@
+// Package poc turns the requests recorded with a finding (Finding.PoC) into standalone proofs
+// of concept: the raw bytes of each connection, a Go program built on the smuggler clients, a
+// Python script with plain sockets and a Turbo Intruder script. every format sends the attack
+// and victim requests exactly as the scan did, lengths and chunk sizes included.
+package poc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"smuggler/smuggler"
+	"strings"
+)
+
+var Formats = []string{"raw", "go", "python", "turbo"}
+
+// a file of a PoC, Name is the suffix of the file name (extension included)
+type File struct {
+	Name string
+	Data []byte
+}
+
+// the PoC of f in format, raw PoCs have a file per connection
+func Render(format string, f smuggler.Finding) ([]File, error) {
+	p, err := newPoC(f)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(format) {
+	case "raw":
+		return p.raw()
+	case "go":
+		return p.golang()
+	case "python":
+		return p.python()
+	case "turbo":
+		return p.turbo()
+	}
+	return nil, fmt.Errorf("unknown PoC format: %s: valid formats: %s", format, strings.Join(Formats, ","))
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// writes the PoCs of f in dir/<host>/, the paths of the files are returned
+func Export(dir string, formats []string, f smuggler.Finding) ([]string, error) {
+	u, err := url.Parse(f.Target)
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, u.Hostname())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := unsafeChars.ReplaceAllString(string(f.Technique), "")
+	if len(f.Mutation) > 0 {
+		name += "-" + unsafeChars.ReplaceAllString(f.Mutation, "_")
+	}
+	name = strings.ToLower(name)
+
+	var paths []string
+	for _, format := range formats {
+		files, err := Render(format, f)
+		if err != nil {
+			return paths, err
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, name+file.Name)
+			if err := os.WriteFile(path, file.Data, 0644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// what the templates are filled with
+type poc struct {
+	smuggler.Finding
+	URL   *url.URL
+	TLS   bool
+	Addr  string // host:port of the target
+	Dial  string // host:port dialed (ConnectTo or Addr)
+	H2    bool
+	Conns [][]smuggler.PoCRequest // requests grouped by connection, in order
+}
+
+func newPoC(f smuggler.Finding) (*poc, error) {
+	if len(f.PoC) == 0 {
+		return nil, errors.New("the finding has no PoC requests")
+	}
+	u, err := url.Parse(f.Target)
+	if err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("invalid target: %q", f.Target)
+	}
+	p := &poc{Finding: f, URL: u, TLS: u.Scheme == "https", H2: f.PoC[0].Proto == smuggler.ProtoH2}
+
+	port := u.Port()
+	if len(port) == 0 {
+		port = "80"
+		if p.TLS || p.H2 { // h2 is only spoken over TLS
+			port = "443"
+		}
+	}
+	p.Addr = net.JoinHostPort(u.Hostname(), port)
+	p.Dial = p.Addr
+	if len(f.ConnectTo) > 0 {
+		p.Dial = f.ConnectTo
+	}
+	if p.H2 {
+		p.TLS = true
+	}
+
+	conns := make(map[int][]smuggler.PoCRequest)
+	var ids []int
+	for _, r := range f.PoC {
+		if r.Proto != f.PoC[0].Proto {
+			return nil, errors.New("the PoC mixes HTTP/1.1 and HTTP/2 requests")
+		}
+		if _, ok := conns[r.Conn]; !ok {
+			ids = append(ids, r.Conn)
+		}
+		conns[r.Conn] = append(conns[r.Conn], r)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		p.Conns = append(p.Conns, conns[id])
+	}
+	return p, nil
+}
+
+// host and port of the address dialed
+func (p *poc) DialHost() string {
+	host, _, _ := net.SplitHostPort(p.Dial)
+	return host
+}
+
+func (p *poc) DialPort() string {
+	_, port, _ := net.SplitHostPort(p.Dial)
+	return port
+}
+
+// the most requests sent on one connection
+func (p *poc) PerConn() int {
+	n := 0
+	for _, c := range p.Conns {
+		n = max(n, len(c))
+	}
+	return n
+}
+
+// the role of the i-th request of the PoC (all connections)
+func (p *poc) role(i int) string {
+	if i < len(p.PoC)-1 || len(p.PoC) == 1 {
+		return "attack"
+	}
+	return "victim"
+}
+
+// a string literal of s in a Python source (bytes if prefix is "b"), every byte is kept
+func pyString(prefix, s string) string {
+	var sb strings.Builder
+	sb.WriteString(prefix + "'")
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '\'':
+			sb.WriteString(`\` + string(c))
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteString("'")
+	return sb.String()
+}
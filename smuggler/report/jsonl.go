@@ -0,0 +1,29 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"smuggler/smuggler"
+	"sync"
+)
+
+// one finding per line, written as soon as it is found
+type jsonl struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonl) Write(f smuggler.Finding) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(b, '\n'))
+	return err
+}
+
+func (j *jsonl) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+// Package report writes findings in machine-readable formats (JSON Lines, SARIF) and
+// as a self-contained HTML page.
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"smuggler/smuggler"
+	"strings"
+	"sync"
+)
+
+type Writer interface {
+	Write(f smuggler.Finding) error
+	Close() error // flushes buffered findings, the underlying writer is not closed
+}
+
+var Formats = []string{"jsonl", "sarif", "html"}
+
+func New(format string, w io.Writer) (Writer, error) {
+	switch strings.ToLower(format) {
+	case "jsonl":
+		return &jsonl{w: w}, nil
+	case "sarif":
+		return &sarif{w: w}, nil
+	case "html":
+		return &html{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown report format: %s: valid formats: %s", format, strings.Join(Formats, ","))
+}
+
+// guesses a format from a file extension, jsonl if unknown
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".sarif":
+		return "sarif"
+	case ".html", ".htm":
+		return "html"
+	}
+	return "jsonl"
+}
+
+// findings are buffered until Close for formats that are a single document
+type buffered struct {
+	mu       sync.Mutex
+	findings []smuggler.Finding
+}
+
+func (b *buffered) Write(f smuggler.Finding) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.findings = append(b.findings, f)
+	return nil
+}
@@ -0,0 +1,129 @@
+package report_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"smuggler/smuggler"
+	"smuggler/smuggler/report"
+	"strings"
+	"testing"
+	"time"
+)
+
+func findings() []smuggler.Finding {
+	return []smuggler.Finding{
+		{
+			Target:    "https://example.com/",
+			Technique: smuggler.CLTE,
+			Header:    "Transfer-Encoding:\x0bchunked",
+			Probes: []smuggler.Probe{
+				{Outcome: "timeout", Request: "POST / HTTP/1.1\r\n\r\n1\r\nG", Duration: time.Second * 5},
+				{Outcome: "normal", Request: "POST / HTTP/1.1\r\n\r\n1\r\nG\r\n0\r\n\r\n", Duration: time.Millisecond * 40, Status: 200},
+			},
+			Confidence: 0.75,
+			Signals:    []smuggler.Signal{{Name: "timeout/normal pairs", Ratio: 27}, {Name: "control request", Ratio: 3}},
+			Controls:   []smuggler.Probe{{Outcome: "normal", Request: "POST / HTTP/1.1\r\n\r\n1\r\nG", Status: 200}},
+			Request:    "POST / HTTP/1.1\r\nHost: example.com\r\n\r\n<script>",
+			Confirmed:  true,
+			Disruptive: true,
+			Evidence: []smuggler.Probe{
+				{Outcome: "normal", Request: "POST / HTTP/1.1\r\n\r\n0\r\n\r\nGET /404 HTTP/1.1\r\nX-Ignore: X", Status: 200},
+				{Outcome: "normal", Request: "GET / HTTP/1.1\r\n\r\n", Status: 404, Response: "HTTP/1.1 404 Not Found\r\n\r\n"},
+			},
+		},
+		{Target: "https://example.org/", Technique: smuggler.H2TE, Confidence: 0.3},
+	}
+}
+
+func write(t *testing.T, format string) []byte {
+	var buf bytes.Buffer
+	w, err := report.New(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range findings() {
+		if err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestJSONL(t *testing.T) {
+	scanner := bufio.NewScanner(bytes.NewReader(write(t, "jsonl")))
+	var got []smuggler.Finding
+	for scanner.Scan() {
+		var f smuggler.Finding
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, f)
+	}
+	want := findings()
+	if len(got) != len(want) {
+		t.Fatalf("Wanted: %d findings, Got: %d", len(want), len(got))
+	}
+	if got[0].Header != want[0].Header || got[0].Probes[1].Status != 200 || got[0].Probes[0].Duration != time.Second*5 ||
+		!got[0].Confirmed || got[0].Evidence[1].Response != want[0].Evidence[1].Response {
+		t.Errorf("Wanted: %+v, Got: %+v", want[0], got[0])
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID string `json:"ruleId"`
+				Level  string `json:"level"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(write(t, "sarif"), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("invalid sarif log: %+v", log)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 2 {
+		t.Fatalf("Wanted: 2 rules and 2 results, Got: %d and %d", len(run.Tool.Driver.Rules), len(run.Results))
+	}
+	if run.Results[0].RuleID != "CL.TE" || run.Results[0].Level != "warning" || run.Results[1].Level != "note" {
+		t.Errorf("unexpected results: %+v", run.Results)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	out := string(write(t, "html"))
+	for _, want := range []string{"CL.TE", "Transfer-Encoding:\\x0Bchunked", "&lt;script&gt;", "75%", "Victim (404)", "HTTP/1.1 404 Not Found", "control request</td><td>3.00", "Control 0 (normal)", "potentially disruptive"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Wanted %q in html report", want)
+		}
+	}
+	if strings.Contains(out, "<script>") {
+		t.Error("request isn't escaped")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	if _, err := report.New("xml", nil); err == nil {
+		t.Error("Wanted an error for an unknown format")
+	}
+	for path, want := range map[string]string{"a.sarif": "sarif", "a.HTML": "html", "a.json": "jsonl", "a": "jsonl"} {
+		if got := report.FormatFromPath(path); got != want {
+			t.Errorf("%s: Wanted: %s, Got: %s", path, want, got)
+		}
+	}
+}
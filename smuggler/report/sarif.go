@@ -0,0 +1,115 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"smuggler/smuggler"
+)
+
+// SARIF 2.1.0 log, one run with a rule per technique
+type sarif struct {
+	buffered
+	w io.Writer
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties smuggler.Finding  `json:"properties"`
+	Rank       float64           `json:"rank"`
+	Partial    map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+}
+
+func sarifLevel(confidence float64) string {
+	switch {
+	case confidence >= 0.8:
+		return "error"
+	case confidence >= 0.5:
+		return "warning"
+	}
+	return "note"
+}
+
+func (s *sarif) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "smuggler",
+			InformationURI: "https://github.com/AkewakBiru/smuggler",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	seen := make(map[smuggler.Technique]bool)
+	for _, f := range s.findings {
+		if !seen[f.Technique] {
+			seen[f.Technique] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:               string(f.Technique),
+				ShortDescription: sarifMessage{Text: f.Technique.Description()},
+			})
+		}
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = f.Target
+		run.Results = append(run.Results, sarifResult{
+			RuleID:     string(f.Technique),
+			Level:      sarifLevel(f.Confidence),
+			Message:    sarifMessage{Text: fmt.Sprintf("Potential %s desync on %s", f.Technique, f.Target)},
+			Locations:  []sarifLocation{loc},
+			Properties: f,
+			Rank:       f.Confidence * 100,
+			Partial:    map[string]string{"technique/header": fmt.Sprintf("%s/%q", f.Technique, f.Header)},
+		})
+	}
+
+	enc := json.NewEncoder(s.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
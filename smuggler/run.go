@@ -2,7 +2,6 @@ package smuggler
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -18,8 +17,11 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"os"
-	"smuggler/config"
+	"path/filepath"
 	"smuggler/smuggler/h1"
+	"smuggler/smuggler/h2"
+	"smuggler/smuggler/tests"
+	"smuggler/smuggler/throttle"
 	"smuggler/utils"
 	"strings"
 	"time"
@@ -29,10 +31,10 @@ import (
 // sneaks in a request in another request, the synchronization will be affected resulting in
 // weird behaviours (users receiving response meant to be received by other users)
 type Desyncer interface {
-	H1Test(*h1.Payload) (int, error)
+	H1Test(*h1.Payload) (*Probe, error)
 	GetCookie() error
 	getCookie(bool) error
-	RunTests() error
+	RunTests([]Detector)
 	ParseURL(host string) error
 }
 
@@ -49,11 +51,21 @@ type DesyncerImpl struct {
 
 	Hdr map[string][]string
 
-	TestDone chan struct{} // closed on success, if exit-on-success is set
+	Opts *Options
 
-	Wg     sync.WaitGroup
 	Ctx    context.Context
 	Cancel context.CancelFunc
+
+	mu       sync.Mutex
+	findings []Finding
+
+	h2mu sync.Mutex
+	h2c  *h2.ClientConn // shared by the h2 requests to the target
+
+	h1cal *calibration // latency of the target, nil if it wasn't calibrated
+	h2cal *calibration
+
+	progress func(detector string, found []Finding) // see Target.Progress
 }
 
 func (d *DesyncerImpl) ParseURL(uri string) error {
@@ -96,22 +108,32 @@ func (d *DesyncerImpl) ParseURL(uri string) error {
 	return nil
 }
 
-// builds a new payload
+// header mutations of type t for the scan level, in a reproducible order
+func (d *DesyncerImpl) mutations(t tests.PTYPE) []tests.Mutation {
+	g := tests.Generator{Shuffle: d.Opts.Shuffle, Seed: d.Opts.Seed, Packs: d.Opts.Packs}
+	return g.Generate(t, d.Opts.Level)
+}
+
+// builds a new payload, header order is fixed: Host, headers included in all requests then
+// per-host headers (each group sorted by name), so the same payload is always the same bytes
 func (d *DesyncerImpl) NewPl(pl string) *h1.Payload {
 	payload := h1.Payload{HdrPl: pl, URL: *d.URL, Method: d.Method}
-	headers := make(map[string]string)
-	// headers["Content-Type"] = "application/x-www-form-urlencoded"
-	headers["Host"] = d.URL.Host         // this is causing a big issue // set it to just host if port is 80/443 else host:port
-	for k, vv := range config.Glob.Hdr { // headers included in all requests
-		headers[k] = vv[0]
-	}
-	payload.Header = headers
-	for k, v := range d.Hdr { // per-host headers
-		payload.Header[k] = strings.Join(v, "; ") // applies to cookies only for now
+	// payload.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	payload.Header.Add("Host", d.URL.Host) // this is causing a big issue // set it to just host if port is 80/443 else host:port
+	for _, f := range h1.HeaderFromMap(d.Opts.Headers) {
+		payload.Header.Add(f.Name, f.Value)
 	}
 
-	if len(d.Hdr["Cookie"]) > 0 {
-		payload.Header["Cookie"] = strings.Join(d.Hdr["Cookie"], "; ")
+	hdr := utils.CloneMap(d.Hdr)
+	delete(hdr, "Cookie")
+	for _, f := range h1.HeaderFromMap(hdr) { // per-host headers replace global ones with the same name
+		payload.Header.Del(f.Name)
+	}
+	for _, f := range h1.HeaderFromMap(hdr) {
+		payload.Header.Add(f.Name, f.Value)
+	}
+	if len(d.Hdr["Cookie"]) > 0 { // a single cookie line
+		payload.Header.Set("Cookie", strings.Join(d.Hdr["Cookie"], "; "))
 	}
 	return &payload
 }
@@ -138,9 +160,10 @@ func (d *DesyncerImpl) GetCookie() error {
 func (d *DesyncerImpl) getCookie(forceH2 bool) error {
 	t := &http.Transport{
 		ForceAttemptHTTP2: forceH2,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+		TLSClientConfig:   d.Opts.TLS.Config(), // h2 is added by ForceAttemptHTTP2 unless ALPN is set
+	}
+	if d.Opts.Dialer != nil {
+		t.DialContext = d.Opts.Dialer.DialContext
 	}
 
 	jar, err := cookiejar.New(nil)
@@ -150,7 +173,7 @@ func (d *DesyncerImpl) getCookie(forceH2 bool) error {
 
 	client := &http.Client{
 		Jar:       jar,
-		Transport: t,
+		Transport: throttled{d.Opts.Throttle, d.Ctx, t},
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) > 10 {
 				return http.ErrUseLastResponse
@@ -163,9 +186,20 @@ func (d *DesyncerImpl) getCookie(forceH2 bool) error {
 		Timeout: time.Second * 5, // wait for 5 seconds for a response
 	}
 
-	req, _ := http.NewRequest(d.Method, d.URL.String(), nil)
-	for k, vv := range config.Glob.Hdr {
-		req.Header[k] = append(req.Header[k], vv...)
+	// the request of the target as it is (headers, cookies and body), an endpoint that needs a
+	// session must accept it before it's probed
+	var body io.Reader
+	if len(d.Body) > 0 {
+		body = strings.NewReader(d.Body)
+	}
+	req, err := http.NewRequest(d.Method, d.URL.String(), body)
+	if err != nil {
+		return err
+	}
+	for _, f := range d.NewPl("").Header {
+		if !strings.EqualFold(f.Name, "Host") {
+			req.Header.Add(f.Name, f.Value)
+		}
 	}
 
 	resp, err := client.Do(req)
@@ -197,136 +231,155 @@ func (d *DesyncerImpl) getCookie(forceH2 bool) error {
 	return nil
 }
 
-// tests run concurrently
-func (d *DesyncerImpl) runTestsC() {
-	cl := CL{DesyncerImpl: d}
-	te := TE{DesyncerImpl: d}
-	h2 := H2{DesyncerImpl: d}
-
-	d.Wg.Add(3) //increase delta when more tests are added
-	go cl.Run()
-	go te.Run()
-	go h2.Run()
-
-	go func() {
-		d.Wg.Wait()
-		close(d.TestDone) // wait for all and close the channel
-	}()
-
-	select {
-	case <-d.TestDone: // signaled when one test is done, only signaled when exit on success is set
-		d.Cancel()
-	case <-d.Ctx.Done(): // signaled when all tests are complete
-	}
-}
-
-// tests run sequentially
-func (d *DesyncerImpl) runTestsN() {
-	cl := CL{DesyncerImpl: d}
-	te := TE{DesyncerImpl: d}
-	h2 := H2{DesyncerImpl: d}
-
-	tests := map[config.Priority][]func() bool{
-		config.CLTEH2: {cl.Run, te.Run, h2.Run},
-		config.CLH2TE: {cl.Run, h2.Run, te.Run},
-		config.H2TECL: {h2.Run, te.Run, cl.Run},
-		config.H2CLTE: {h2.Run, cl.Run, te.Run},
-		config.TECLH2: {te.Run, cl.Run, h2.Run},
-		config.TEH2CL: {te.Run, h2.Run, cl.Run},
-	}
-
-	for _, testFunc := range tests[config.Glob.Priority] {
-		if testFunc() {
-			return
+// runs the detectors the target supports, in order (or all at once if concurrent). on
+// exit-on-success, the first detector with a finding stops the rest
+func (d *DesyncerImpl) RunTests(dets []Detector) {
+	var wg sync.WaitGroup
+	for _, det := range dets {
+		if !det.Supported(d) {
+			continue
 		}
+		if !d.Opts.Concurrent {
+			if len(d.run(det)) > 0 && d.Opts.ExitEarly || d.Ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if len(d.run(det)) > 0 && d.Opts.ExitEarly {
+				d.Cancel()
+			}
+		}()
 	}
+	wg.Wait() // findings must not be added after the scan returns
 }
 
-func (d *DesyncerImpl) RunTests() {
-	if config.Glob.Concurrent {
-		d.runTestsC()
-		return
+// runs det, its findings are reported as progress unless it was interrupted
+func (d *DesyncerImpl) run(det Detector) []Finding {
+	found := det.Run(d.Ctx, d)
+	if d.progress != nil && d.Ctx.Err() == nil {
+		d.progress(strings.ToLower(det.Name()), found)
 	}
-	d.runTestsN()
+	return found
 }
 
-func (d *DesyncerImpl) H1Test(p *h1.Payload) (int, error) {
-	t := h1.Transport{}
+func (d *DesyncerImpl) H1Test(p *h1.Payload) (*Probe, error) {
+	t := h1.Transport{TLS: d.Opts.TLS, Dialer: d.Opts.Dialer}
+	path := p.URL.Path
 	p.URL = *d.URL
+	if len(path) > 0 {
+		p.URL.Path = path
+	}
 	q := p.URL.Query()
 	q.Set("t", fmt.Sprintf("%d", rand.Int32N(math.MaxInt32))) // avoid caching
 	p.URL.RawQuery = q.Encode()
+	raw := p.ToString()
+	timeout, early := d.timeouts(false)
+	release, err := d.acquire()
+	if err != nil {
+		return newProbe(ProbeError, raw, 0, 0), err
+	}
+	defer release()
 	start := time.Now()
-	resp, err := t.RoundTrip(&h1.Request{Url: &p.URL, Payload: p, Timeout: config.Glob.Timeout})
+	resp, err := t.RoundTrip(&h1.Request{Url: &p.URL, Payload: p, Timeout: timeout})
+	diff := time.Since(start)
+	d.observe(resp)
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) || strings.Compare(err.Error(), "read timeout") == 0 {
-			return 1, err // deadline exceeds after waiting for 'timeout' seconds
+			return newProbe(ProbeTimeout, raw, diff, 0), err // deadline exceeds after waiting for 'timeout' seconds
 		}
-		return -1, err
+		return newProbe(ProbeError, raw, diff, 0), err
 	}
-	diff := time.Since(start)
 	defer resp.Body.Close()
 
 	var sample []byte = make([]byte, 100)
-	if _, err = resp.Body.Read(sample); err != nil && err != io.EOF {
-		return -1, fmt.Errorf("socket error: %v", err)
+	n, err := resp.Body.Read(sample)
+	if err != nil && err != io.EOF {
+		return newProbe(ProbeError, raw, diff, resp.StatusCode), fmt.Errorf("socket error: %v", err)
 	}
-	if len(sample) == 0 {
-		if diff < time.Duration(config.Glob.Timeout-time.Second) {
-			return 2, nil // disconnected before timeout
+	if n == 0 { // no body
+		if diff < early {
+			return newProbe(ProbeDisconnected, raw, diff, resp.StatusCode), nil // disconnected before timeout
 		}
-		return 1, nil // connection timeout (probably)
+		return newProbe(ProbeTimeout, raw, diff, resp.StatusCode), nil // connection timeout (probably)
 	}
-	return 0, nil // normal response
+	ret := newProbe(ProbeNormal, raw, diff, resp.StatusCode) // normal response
+	ret.Response = dumpResponse(resp)
+	return ret, nil
 }
 
-func (d *DesyncerImpl) GenReport(p *h1.Payload) {
-	if err := createDir("/result/"); err != nil {
-		log.Warn().Err(err).Msg("")
+// waits for the throttle to allow a request to the target, release must be called when it's done
+func (d *DesyncerImpl) acquire() (release func(), err error) {
+	return d.Opts.Throttle.Acquire(d.Ctx, d.URL.Host)
+}
+
+// a 429 or 503 response of the target backs off the next requests
+func (d *DesyncerImpl) observe(resp *http.Response) {
+	d.Opts.Throttle.Observe(d.URL.Host, resp)
+}
+
+// a RoundTripper throttled by the host of each request (redirects may go to other hosts)
+type throttled struct {
+	l   *throttle.Limiter
+	ctx context.Context
+	rt  http.RoundTripper
+}
+
+func (t throttled) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.l.Acquire(t.ctx, req.URL.Host)
+	if err != nil {
+		return nil, err
 	}
-	if err := createDir(fmt.Sprintf("/result/%s", d.URL.Hostname())); err != nil {
-		log.Warn().Err(err).Msg("")
+	defer release()
+	resp, err := t.rt.RoundTrip(req)
+	t.l.Observe(req.URL.Host, resp)
+	return resp, err
+}
+
+// records a finding for an h1 payload, the PoC is also stored in the report directory. false if
+// the finding is under the confidence threshold
+func (d *DesyncerImpl) GenReport(p *h1.Payload, f Finding) bool {
+	if len(f.Header) == 0 {
+		f.Header = p.HdrPl
 	}
-	pwd, err := os.Getwd()
-	if err != nil {
+	f.Request = p.ToString()
+	if len(f.PoC) == 0 {
+		f.PoC = d.h1PoC(p)
+	}
+	if !d.addFinding(f) {
+		return false
+	}
+
+	esc := *p // same header order as the request that was sent, non-printable bytes escaped
+	esc.HdrPl = utils.HexEscapeNonPrintable(p.HdrPl)
+	esc.Header = p.Header.Clone()
+	for i, f := range esc.Header {
+		esc.Header[i] = h1.HeaderField{Name: utils.HexEscapeNonPrintable(f.Name), Value: utils.HexEscapeNonPrintable(f.Value)}
+	}
+	d.writeReport(p.URL.Query().Get("t"), esc.ToString())
+	return true
+}
+
+// stores a PoC request in <ReportDir>/<host>/<name>s
+func (d *DesyncerImpl) writeReport(name, req string) {
+	if len(d.Opts.ReportDir) == 0 {
+		return
+	}
+	dir := filepath.Join(d.Opts.ReportDir, d.URL.Hostname())
+	if err := os.MkdirAll(dir, 0777); err != nil {
 		log.Warn().Err(err).Msg("")
 		return
 	}
-	fname := fmt.Sprintf("%s/result/%s/%ss", pwd, d.URL.Hostname(), p.URL.Query().Get("t"))
-	file, err := os.OpenFile(fname, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	file, err := os.OpenFile(filepath.Join(dir, name+"s"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		log.Warn().Err(err).Msg("")
 		return
 	}
 	defer file.Close()
 
-	p.HdrPl = utils.HexEscapeNonPrintable(p.HdrPl)
-	file.WriteString(p.ToString())
-}
-
-func createDir(dir string) error {
-	pwd, err := os.Getwd()
-	if err != nil {
-		return err
+	if _, err := file.WriteString(req); err != nil {
+		log.Warn().Err(err).Msg("Failed to write report to file")
 	}
-	info, err := os.Stat(pwd + dir)
-	flag := true
-	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return err
-		}
-		flag = false
-	}
-	if flag {
-		if !info.IsDir() {
-			if err := os.Mkdir(pwd+dir, 0777); err != nil {
-				return err
-			}
-		}
-	} else {
-		if err := os.Mkdir(pwd+dir, 0777); err != nil {
-			return err
-		}
-	}
-	return nil
 }
@@ -0,0 +1,133 @@
+package smuggler
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"smuggler/config"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/tests"
+	"smuggler/smuggler/throttle"
+	"smuggler/smuggler/tlsconf"
+	"smuggler/utils"
+	"strings"
+	"time"
+)
+
+// Options for a Scanner, two scanners with different options can live in the same process
+type Options struct {
+	Level config.LEVEL
+
+	ExitEarly  bool // stop scanning a target on the first finding
+	Concurrent bool // run every technique of a target concurrently
+
+	Techniques []string // detector names in the order they are run, all registered detectors if empty
+
+	Shuffle bool   // shuffle the header mutations of each technique
+	Seed    uint64 // seed of the shuffle, a random one is picked if zero
+
+	Packs []tests.PackEntry // mutations tried after the built-in ones
+
+	Timeout time.Duration    // per-request timeout to decide if there is a desync issue, until the target is calibrated
+	TLS     *tlsconf.Options // client options of every TLS connection, nil for the defaults
+	Dialer  dialer.Dialer    // every connection is opened with it (upstream proxy), nil to dial directly
+	Confirm bool             // timing hits are only reported when a victim request gets the smuggled response
+
+	NoCalibrate   bool    // always use Timeout instead of deriving it from the latency of the target
+	MinConfidence float64 // findings under it aren't reported unless they are confirmed [0-1]
+
+	Throttle *throttle.Limiter // rate limits shared by every scan, nil for none
+
+	Safe bool // only techniques whose probes can't leave a prefix in a back-end queue, no confirmation
+
+	Headers map[string][]string // headers sent in all requests
+
+	ReportDir string // directory where PoC requests are stored, nothing is written if empty
+}
+
+// per-target request gadgets (these must be sent for a request to work)
+type Target struct {
+	URL     string
+	Method  string
+	Body    string
+	Headers map[string][]string
+
+	Skip     []string                               // detectors that aren't run, e.g. the ones a resumed scan already ran
+	Progress func(detector string, found []Finding) // called when a detector ran to completion, nil for none
+}
+
+type Scanner struct {
+	opts Options
+}
+
+func NewScanner(opts Options) *Scanner {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second * 5
+	}
+	if opts.Shuffle && opts.Seed == 0 {
+		opts.Seed = rand.Uint64()
+	}
+	opts.Headers = utils.CloneMap(opts.Headers)
+	return &Scanner{opts: opts}
+}
+
+func (s *Scanner) Options() Options {
+	return s.opts
+}
+
+// runs the selected techniques against a target, the scan stops early when ctx is canceled
+func (s *Scanner) Scan(ctx context.Context, target Target) ([]Finding, error) {
+	d := &DesyncerImpl{
+		Opts:   &s.opts,
+		Hdr:    utils.CloneMap(target.Headers),
+		Method: target.Method,
+		Body:   target.Body,
+	}
+	if len(d.Method) == 0 {
+		d.Method = "POST"
+	}
+	d.Ctx, d.Cancel = context.WithCancel(ctx)
+	defer d.Cancel()
+	defer d.closeH2()
+	dets, err := lookupDetectors(s.opts.Techniques)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Safe {
+		if s.opts.Confirm {
+			return nil, errors.New("confirmation smuggles a request, it can't be used in safe mode")
+		}
+		if dets, err = safeDetectors(dets, len(s.opts.Techniques) > 0); err != nil {
+			return nil, err
+		}
+	}
+
+	dets = slices.DeleteFunc(dets, func(det Detector) bool {
+		return slices.Contains(target.Skip, strings.ToLower(det.Name()))
+	})
+	if len(dets) == 0 {
+		return nil, ctx.Err()
+	}
+	d.progress = target.Progress
+
+	if err := d.ParseURL(target.URL); err != nil {
+		return nil, err
+	}
+
+	if err := d.GetCookie(); err != nil {
+		return nil, err
+	}
+
+	if len(d.Hdr["Cookie"]) == 0 {
+		orig := *d.URL
+		d.URL.Path = "/" // check for cookies on URL root
+		if err := d.GetCookie(); err != nil {
+			return nil, err
+		}
+		d.URL = &orig
+	}
+	d.calibrate()
+	d.RunTests(dets)
+	return d.Findings(), ctx.Err()
+}
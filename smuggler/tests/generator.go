@@ -2,8 +2,10 @@ package tests
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"smuggler/config"
 	"smuggler/smuggler/h2"
+	"strings"
 )
 
 // payload type [CL,TE] and test level [1-3]
@@ -29,10 +31,53 @@ func (t PTYPE) String() string {
 	return fmt.Sprintf("unknown type name: %c", t)
 }
 
-type Generator struct{}
+// a single header mutation. the ID (<type>-<level>-<index>) only depends on the type, level
+// and position in the unshuffled list, so it identifies the same bytes across runs
+type Mutation struct {
+	ID   string
+	Type PTYPE
+	Key  string // header name as sent
+	Val  string // header value as sent (no space is added after the colon), empty for CL
+	Tags []string
+}
+
+// the header line, for CL only the (mutated) name is known, the detector adds the value
+func (m Mutation) Line() string {
+	return fmt.Sprintf("%s:%s", m.Key, m.Val)
+}
+
+// the header without any mutation
+func Plain(t PTYPE) Mutation {
+	m := Mutation{ID: fmt.Sprintf("%s-000", t), Type: t}
+	switch t {
+	case TE:
+		m.Key, m.Val = "Transfer-Encoding", " chunked"
+	case CL:
+		m.Key = "Content-Length"
+	}
+	return m
+}
 
-func (g *Generator) Generate(_type PTYPE, level config.LEVEL) map[string][]string {
-	generators := map[PTYPE]map[config.LEVEL]func() map[string][]string{
+type mutations []Mutation
+
+func (l *mutations) add(key string, vals ...string) {
+	for _, v := range vals {
+		*l = append(*l, Mutation{Key: key, Val: v})
+	}
+}
+
+// the order of the mutations is the order they are added in, unless Shuffle is set,
+// then the same Seed gives the same order
+type Generator struct {
+	Shuffle bool
+	Seed    uint64
+
+	Packs []PackEntry // added after the built-in mutations
+}
+
+// ordered list of mutations without duplicates, built-in mutations come first
+func (g *Generator) Generate(_type PTYPE, level config.LEVEL) []Mutation {
+	generators := map[PTYPE]map[config.LEVEL]func() mutations{
 		TE: {
 			config.B: g.generateTEBasic,
 			config.M: g.generateTEModerate,
@@ -44,30 +89,55 @@ func (g *Generator) Generate(_type PTYPE, level config.LEVEL) map[string][]strin
 			config.E: g.generateCLExhaustive,
 		},
 		CRLF: {
-			config.B: g.generateCRLF,
-			config.M: g.generateCRLF,
-			config.E: g.generateCRLF,
+			config.B: func() mutations { return g.generateCRLF(config.B) },
+			config.M: func() mutations { return g.generateCRLF(config.M) },
+			config.E: func() mutations { return g.generateCRLF(config.E) },
 		},
 	}
 
-	if gentype, found := generators[_type]; found {
-		if generator, levelFound := gentype[level]; levelFound {
-			return generator()
-		}
+	gentype, found := generators[_type]
+	if !found {
 		return nil
 	}
-	return nil
+	generator, found := gentype[level]
+	if !found {
+		return nil
+	}
+
+	seen := make(map[[2]string]bool)
+	var res []Mutation
+	for _, m := range generator() {
+		if seen[[2]string{m.Key, m.Val}] {
+			continue
+		}
+		seen[[2]string{m.Key, m.Val}] = true
+		m.Type = _type
+		m.ID = fmt.Sprintf("%s-%s-%03d", _type, level, len(res)+1)
+		res = append(res, m)
+	}
+	for _, e := range g.Packs {
+		if e.Type != _type || (!e.All && e.Level != level) || seen[[2]string{e.Key, e.Val}] {
+			continue
+		}
+		seen[[2]string{e.Key, e.Val}] = true
+		res = append(res, e.Mutation)
+	}
+	if g.Shuffle {
+		r := rand.New(rand.NewPCG(g.Seed, uint64(_type)<<8|uint64(level)))
+		r.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+	}
+	return res
 }
 
 // keep the same formating when joining to make a header (no space after colon)
-func (g *Generator) generateTEBasic() map[string][]string {
-	te := make(map[string][]string)
+func (g *Generator) generateTEBasic() mutations {
+	var te mutations
 
-	te["Transfer-Encoding"] = []string{" chunked"}
-	te[" Transfer-Encoding"] = []string{" chunked"}
-	te["Transfer-Encoding"] = []string{"\tchunked"}
-	te["Transfer-Encoding\t"] = []string{"\tchunked"}
-	te[" Transfer-Encoding "] = []string{" chunked"}
+	te.add("Transfer-Encoding", " chunked")
+	te.add(" Transfer-Encoding", " chunked")
+	te.add("Transfer-Encoding", "\tchunked")
+	te.add("Transfer-Encoding\t", "\tchunked")
+	te.add(" Transfer-Encoding ", " chunked")
 
 	chars := []byte{0x1, 0x4, 0x8, 0x9, 0xa, 0xb, 0xc, 0xd, 0x1F, 0x20, 0x7f, 0xA0, 0xFF}
 	keys := []string{
@@ -86,18 +156,17 @@ func (g *Generator) generateTEBasic() map[string][]string {
 	}
 	for _, i := range chars {
 		for _, v := range vals {
-			te["Transfer-Encoding"] = append(te["Transfer-Encoding"], fmt.Sprintf(v, i))
+			te.add("Transfer-Encoding", fmt.Sprintf(v, i))
 		}
 		for _, v := range keys {
-			key := fmt.Sprintf(v, i)
-			te[key] = append(te[key], " chunked")
+			te.add(fmt.Sprintf(v, i), " chunked")
 		}
 	}
 	return te
 }
 
-func (g *Generator) generateTEModerate() map[string][]string {
-	te := make(map[string][]string)
+func (g *Generator) generateTEModerate() mutations {
+	var te mutations
 	ranges := [2][2]int{{0x1, 0x21}, {0x7F, 0x100}}
 
 	for _, r := range ranges {
@@ -106,65 +175,61 @@ func (g *Generator) generateTEModerate() map[string][]string {
 			teChar := fmt.Sprintf("%cTransfer-Encoding", i)
 			teCharF := fmt.Sprintf("Transfer-Encoding%c", i)
 
-			te[teCharChar] = append(te[teCharChar], "chunked")
-			te[teChar] = append(te[teChar], fmt.Sprintf("%cchunked", i))
-			te[teChar] = append(te[teChar], fmt.Sprintf(" chunked%c", i))
-			te[teCharF] = append(te[teCharF], fmt.Sprintf("%cchunked", i))
-			te[teCharF] = append(te[teCharF], fmt.Sprintf(" chunked%c", i))
-			te["Transfer-Encoding"] = append(te["Transfer-Encoding"], fmt.Sprintf("%cchunked%c", i, i))
+			te.add(teCharChar, "chunked")
+			te.add(teChar, fmt.Sprintf("%cchunked", i), fmt.Sprintf(" chunked%c", i))
+			te.add(teCharF, fmt.Sprintf("%cchunked", i), fmt.Sprintf(" chunked%c", i))
+			te.add("Transfer-Encoding", fmt.Sprintf("%cchunked%c", i, i))
 		}
 	}
 	return te
 }
 
-func (g *Generator) generateTEExhaustive() map[string][]string {
-	te := make(map[string][]string)
-
-	te[" Transfer-Encoding"] = append(te[" Transfer-Encoding"], " chunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], "\tchunked")
-	te["Transfer-Encoding\t"] = append(te["Transfer-Encoding\t"], "\tchunked")
-	te["Transfer Encoding"] = append(te["Transfer Encoding"], " chunked")
-	te["Transfer_Encoding"] = append(te["Transfer_Encoding"], " chunked")
-	te["Transfer Encoding"] = append(te["Transfer Encoding"], "chunked")
-	te["Transfer-Encoding "] = append(te["Transfer-Encoding "], "chunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], "  chunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], "\u000Bchunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " chunked, cow")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " cow, chunked")
-	te["Content-Encoding"] = append(te["Content-Encoding"], " chunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], "\n chunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " \"chunked\"")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " 'chunked'")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " chunk")
-	te["TrAnSFer-EnCODinG"] = append(te["TrAnSFer-EnCODinG"], " cHuNkeD")
-	te["TRANSFER-ENCODING"] = append(te["TRANSFER-ENCODING"], " CHUNKED")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " chunked\r")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " chunked\t")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " cow\r\nTransfer-Encoding: chunked")
-	te["Transfer\r-Encoding"] = append(te["Transfer\r-Encoding"], " chunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " cow chunked bar")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], "\xFFchunked")
-	te["Transfer-Encoding"] = append(te["Transfer-Encoding"], " ch\x96nked")
-	te["Transf\x82r-Encoding"] = append(te["Transf\x82r-Encoding"], " chunked")
-	te["X:X\rTransfer-Encoding"] = append(te["X:X\rTransfer-Encoding"], " chunked")
-	te["X:X\nTransfer-Encoding"] = append(te["X:X\nTransfer-Encoding"], " chunked")
+func (g *Generator) generateTEExhaustive() mutations {
+	var te mutations
+
+	te.add(" Transfer-Encoding", " chunked")
+	te.add("Transfer-Encoding", "\tchunked")
+	te.add("Transfer-Encoding\t", "\tchunked")
+	te.add("Transfer Encoding", " chunked")
+	te.add("Transfer_Encoding", " chunked")
+	te.add("Transfer Encoding", "chunked")
+	te.add("Transfer-Encoding ", "chunked")
+	te.add("Transfer-Encoding", "  chunked")
+	te.add("Transfer-Encoding", "\u000Bchunked")
+	te.add("Transfer-Encoding", " chunked, cow")
+	te.add("Transfer-Encoding", " cow, chunked")
+	te.add("Content-Encoding", " chunked")
+	te.add("Transfer-Encoding", "\n chunked")
+	te.add("Transfer-Encoding", " \"chunked\"")
+	te.add("Transfer-Encoding", " 'chunked'")
+	te.add("Transfer-Encoding", " chunk")
+	te.add("TrAnSFer-EnCODinG", " cHuNkeD")
+	te.add("TRANSFER-ENCODING", " CHUNKED")
+	te.add("Transfer-Encoding", " chunked\r")
+	te.add("Transfer-Encoding", " chunked\t")
+	te.add("Transfer-Encoding", " cow\r\nTransfer-Encoding: chunked")
+	te.add("Transfer\r-Encoding", " chunked")
+	te.add("Transfer-Encoding", " cow chunked bar")
+	te.add("Transfer-Encoding", "\xFFchunked")
+	te.add("Transfer-Encoding", " ch\x96nked")
+	te.add("Transf\x82r-Encoding", " chunked")
+	te.add("X:X\rTransfer-Encoding", " chunked")
+	te.add("X:X\nTransfer-Encoding", " chunked")
 
 	ranges := [2][2]int{{0x1, 0x20}, {0x7F, 0x100}}
 	for _, r := range ranges {
 		for i := r[0]; i < r[1]; i++ {
-			te["Transfer-Encoding"] = append(te["Transfer-Encoding"], fmt.Sprintf("%cchunked", i))
-			te["Transfer-Encoding"] = append(te["Transfer-Encoding"], fmt.Sprintf(" chunked%c", i))
-			tmpK := fmt.Sprintf("Transfer-Encoding%c", i)
-			te[tmpK] = append(te[tmpK], " chunked")
-			tmpK = fmt.Sprintf("%cTransfer-Encoding", i)
-			te[tmpK] = append(te[tmpK], " chunked")
+			te.add("Transfer-Encoding", fmt.Sprintf("%cchunked", i), fmt.Sprintf(" chunked%c", i))
+			te.add(fmt.Sprintf("Transfer-Encoding%c", i), " chunked")
+			te.add(fmt.Sprintf("%cTransfer-Encoding", i), " chunked")
 		}
 	}
 	return te
 }
 
-func (g *Generator) generateCLBasic() map[string][]string {
-	cl := make(map[string][]string)
+// the key is the (mutated) header name, the value is added by the detector
+func (g *Generator) generateCLBasic() mutations {
+	var cl mutations
 	chars := []byte{0x1, 0x4, 0x8, 0x9, 0xa, 0xb, 0xc, 0xd, 0x1F, 0x20, 0x7f, 0xA0, 0xFF}
 	vals := []string{
 		"Content-Length%c",
@@ -173,38 +238,34 @@ func (g *Generator) generateCLBasic() map[string][]string {
 		"X: X\r%cContent-Length",
 		"X: X%c\nContent-Length",
 	}
-	cl["Content-Length"] = append(cl["Content-Length"],
-		"Content-Length",
-		" Content-Length",
-		"Content-Length\t",
-		" Content-Length ")
+	for _, k := range []string{"Content-Length", " Content-Length", "Content-Length\t", " Content-Length "} {
+		cl.add(k, "")
+	}
 	for _, ch := range chars {
 		for _, val := range vals {
-			cl["Content-Length"] = append(cl["Content-Length"], fmt.Sprintf(val, ch))
+			cl.add(fmt.Sprintf(val, ch), "")
 		}
 	}
 	return cl
 }
 
-func (g *Generator) generateCLModerate() map[string][]string {
-	cl := make(map[string][]string)
-	cl["Content-Length"] = []string{}
+func (g *Generator) generateCLModerate() mutations {
+	var cl mutations
 
 	ranges := [2][2]int{{0x1, 0x21}, {0x7F, 0x100}}
 	for _, r := range ranges {
 		for i := r[0]; i < r[1]; i++ {
-			cl["Content-Length"] = append(cl["Content-Length"],
-				fmt.Sprintf("%cContent-Length%c", i, i),
-				fmt.Sprintf("%cContent-Length", i),
-				fmt.Sprintf("Content-Length%c", i))
+			cl.add(fmt.Sprintf("%cContent-Length%c", i, i), "")
+			cl.add(fmt.Sprintf("%cContent-Length", i), "")
+			cl.add(fmt.Sprintf("Content-Length%c", i), "")
 		}
 	}
 	return cl
 }
 
-func (g *Generator) generateCLExhaustive() map[string][]string {
-	cl := make(map[string][]string)
-	cl["Content-Length"] = append(cl["Content-Length"],
+func (g *Generator) generateCLExhaustive() mutations {
+	var cl mutations
+	for _, k := range []string{
 		" Content-Length",
 		"Content-Length\t",
 		"Content Length",
@@ -216,14 +277,15 @@ func (g *Generator) generateCLExhaustive() map[string][]string {
 		"Cont\x82nt-Length",
 		"X: X\rContent-Length",
 		"X: X\nContent-Length",
-	)
+	} {
+		cl.add(k, "")
+	}
 
 	ranges := [2][2]int{{0x1, 0x20}, {0x7F, 0x100}}
 	for _, r := range ranges {
 		for i := r[0]; i < r[1]; i++ {
-			cl["Content-Length"] = append(cl["Content-Length"],
-				fmt.Sprintf("Content-Length%c", i),
-				fmt.Sprintf("%cContent-Length", i))
+			cl.add(fmt.Sprintf("Content-Length%c", i), "")
+			cl.add(fmt.Sprintf("%cContent-Length", i), "")
 		}
 	}
 	return cl
@@ -232,23 +294,21 @@ func (g *Generator) generateCLExhaustive() map[string][]string {
 // crlf -> would look like
 // a header + CRLF + Injected header (CL/TE)
 // payload is trying to cause a desync only
-func (g *Generator) generateCRLF() map[string][]string {
-	crlf := make(map[string][]string)
+func (g *Generator) generateCRLF(level config.LEVEL) mutations {
+	var crlf mutations
+	// the CRLF order doesn't depend on the shuffled CL/TE order, the built-in mutations come
+	// first so loading a pack doesn't renumber them
+	builtin, all := Generator{}, Generator{Packs: g.Packs}
+	cl, te := builtin.Generate(CL, level), builtin.Generate(TE, level)
+	packCL, packTE := all.Generate(CL, level)[len(cl):], all.Generate(TE, level)[len(te):]
 
-	cl := g.Generate(CL, config.Glob.Test)
-	for _, vv := range cl {
-		for i, v := range vv {
-			vv[i] = fmt.Sprintf("A\r\n%s", v) // add the value at the req.Payload when sending the request
+	for _, l := range [][2][]Mutation{{cl, te}, {packCL, packTE}} {
+		for _, m := range l[0] {
+			crlf.add("Test1", fmt.Sprintf("A\r\n%s", m.Key)) // add the value at the req.Payload when sending the request
 		}
-		crlf["Test1"] = append(crlf["Test1"], vv...)
-	}
-
-	te := g.Generate(TE, config.Glob.Test)
-	for k, vv := range te {
-		for i, v := range vv {
-			vv[i] = fmt.Sprintf("A\r\n%s:%s", k, v)
+		for _, m := range l[1] {
+			crlf.add("Test", fmt.Sprintf("A\r\n%s", m.Line()))
 		}
-		crlf["Test"] = append(crlf["Test"], vv...)
 	}
 	return crlf
 }
@@ -263,7 +323,7 @@ func (t PTYPE) Body(req *h2.Request, normal bool) {
 		req.Payload.Val = "10"
 	}
 	if t == CRLF {
-		if req.Payload.Key == "Test1" {
+		if req.Payload.Key == "Test1" && !strings.HasSuffix(req.Payload.Val, ": 10") { // called for every probe
 			req.Payload.Val += ": 10"
 		}
 	}
@@ -0,0 +1,83 @@
+package tests_test
+
+import (
+	"reflect"
+	"slices"
+	"smuggler/config"
+	"smuggler/smuggler/tests"
+	"testing"
+)
+
+func ids(muts []tests.Mutation) []string {
+	res := make([]string, len(muts))
+	for i, m := range muts {
+		res[i] = m.ID
+	}
+	return res
+}
+
+func TestGenerateStable(t *testing.T) {
+	for _, _type := range []tests.PTYPE{tests.TE, tests.CL, tests.CRLF} {
+		for _, level := range []config.LEVEL{config.B, config.M, config.E} {
+			g := tests.Generator{}
+			first, second := g.Generate(_type, level), g.Generate(_type, level)
+			if len(first) == 0 || !reflect.DeepEqual(first, second) {
+				t.Errorf("%s-%s: Wanted the same non-empty list on every call", _type, level)
+			}
+
+			seen := make(map[string]bool)
+			for _, m := range first {
+				if seen[m.Line()] {
+					t.Errorf("%s: duplicate mutation %q", m.ID, m.Line())
+				}
+				seen[m.Line()] = true
+			}
+		}
+	}
+}
+
+func TestGenerateIDs(t *testing.T) {
+	g := tests.Generator{}
+	muts := g.Generate(tests.TE, config.B)
+	table := []struct {
+		id   string
+		want string
+	}{
+		{"TE-B-001", "Transfer-Encoding: chunked"},
+		{"TE-B-002", " Transfer-Encoding: chunked"},
+		{"TE-B-003", "Transfer-Encoding:\tchunked"}, // used to overwrite TE-B-001
+	}
+	for _, Case := range table {
+		i := slices.IndexFunc(muts, func(m tests.Mutation) bool { return m.ID == Case.id })
+		if i < 0 {
+			t.Errorf("%s: not found", Case.id)
+			continue
+		}
+		if got := muts[i].Line(); got != Case.want {
+			t.Errorf("%s: Wanted: %q, Got: %q", Case.id, Case.want, got)
+		}
+	}
+}
+
+func TestGenerateShuffle(t *testing.T) {
+	plain := tests.Generator{}
+	a := tests.Generator{Shuffle: true, Seed: 42}
+	b := tests.Generator{Shuffle: true, Seed: 43}
+
+	want := plain.Generate(tests.TE, config.B)
+	got := a.Generate(tests.TE, config.B)
+	if !reflect.DeepEqual(got, a.Generate(tests.TE, config.B)) {
+		t.Error("Wanted the same order for the same seed")
+	}
+	if slices.Equal(ids(got), ids(want)) || slices.Equal(ids(got), ids(b.Generate(tests.TE, config.B))) {
+		t.Error("Wanted a different order for a different seed")
+	}
+
+	// a mutation keeps its ID when shuffled
+	for _, m := range got {
+		i := slices.IndexFunc(want, func(w tests.Mutation) bool { return w.ID == m.ID })
+		if i < 0 || !reflect.DeepEqual(want[i], m) {
+			t.Errorf("%s: Wanted: %+v", m.ID, m)
+		}
+	}
+}
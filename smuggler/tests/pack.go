@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"smuggler/config"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// payload pack: header mutations loaded at runtime, added after the built-in ones.
+//
+// a pack is a text file (*.pack) with one mutation per line, blank lines and lines starting
+// with '#' are ignored:
+//
+//	<type> <level> <key> <value> [tags]
+//
+//	type   TE or CL (CRLF mutations are derived from the TE and CL ones)
+//	level  B (basic), M (double), E (exhaustive) or * for every level
+//	key    header name as sent, a Go quoted string ("Transfer-Encoding\t") or hex:<bytes>
+//	value  header value as sent (no space is added after the colon), same encoding as key.
+//	       for CL it's "" as the detector sets the value
+//	tags   optional, comma separated
+//
+// a '#' after the fields starts a comment, e.g.
+//
+//	TE B "Transfer-Encoding" "\x0bchunked" vtab,value
+//	CL * hex:436f6e74656e742d4c656e677468ff ""
+//
+// the mutations of a pack get the ID <pack>-<type>-<level>-<index>, pack is the file name without
+// the extension, level is A for *, index counts the mutations of the type and level in the pack
+
+const PackExt = ".pack"
+
+var errPackSyntax = errors.New("invalid pack line")
+
+// a mutation of a pack, Level is only used when the pack is loaded
+type PackEntry struct {
+	Mutation
+	Level config.LEVEL
+	All   bool // applies to every level
+}
+
+var typeByName = map[string]PTYPE{"TE": TE, "CL": CL}
+var levelByName = map[string]config.LEVEL{"B": config.B, "M": config.M, "E": config.E}
+
+// reads the pack named name from r
+func ReadPack(r io.Reader, name string) ([]PackEntry, error) {
+	var res []PackEntry
+	count := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	for ln := 1; scanner.Scan(); ln++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		e, err := parsePackLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, ln, err)
+		}
+		level := e.Level.String()
+		if e.All {
+			level = "A"
+		}
+		prefix := fmt.Sprintf("%s-%s-%s", name, e.Type, level)
+		count[prefix]++
+		e.ID = fmt.Sprintf("%s-%03d", prefix, count[prefix])
+		res = append(res, e)
+	}
+	return res, scanner.Err()
+}
+
+func parsePackLine(line string) (PackEntry, error) {
+	var e PackEntry
+	fields, rest, err := splitPackLine(line)
+	if err != nil {
+		return e, err
+	}
+	if len(fields) != 4 {
+		return e, fmt.Errorf("%w: wanted <type> <level> <key> <value> [tags]", errPackSyntax)
+	}
+
+	var ok bool
+	if e.Type, ok = typeByName[strings.ToUpper(fields[0])]; !ok {
+		return e, fmt.Errorf("%w: unknown type %q", errPackSyntax, fields[0])
+	}
+	if fields[1] == "*" {
+		e.All = true
+	} else if e.Level, ok = levelByName[strings.ToUpper(fields[1])]; !ok {
+		return e, fmt.Errorf("%w: unknown level %q", errPackSyntax, fields[1])
+	}
+	if e.Key, err = decodePackString(fields[2]); err != nil {
+		return e, err
+	}
+	if e.Val, err = decodePackString(fields[3]); err != nil {
+		return e, err
+	}
+	if len(e.Key) == 0 {
+		return e, fmt.Errorf("%w: empty key", errPackSyntax)
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	if rest = strings.TrimSpace(rest); len(rest) > 0 {
+		for _, t := range strings.Split(rest, ",") {
+			if t = strings.TrimSpace(t); len(t) > 0 {
+				e.Tags = append(e.Tags, t)
+			}
+		}
+	}
+	return e, nil
+}
+
+// splits the first 4 fields of a line (quoted strings may contain spaces), rest holds the tags
+func splitPackLine(line string) ([]string, string, error) {
+	var fields []string
+	rest := line
+	for len(fields) < 4 {
+		rest = strings.TrimLeft(rest, " \t")
+		if len(rest) == 0 {
+			break
+		}
+		if rest[0] == '"' {
+			q, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, "", fmt.Errorf("%w: %v", errPackSyntax, err)
+			}
+			fields = append(fields, q)
+			rest = rest[len(q):]
+			continue
+		}
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			end = len(rest)
+		}
+		fields = append(fields, rest[:end])
+		rest = rest[end:]
+	}
+	return fields, rest, nil
+}
+
+func decodePackString(s string) (string, error) {
+	if h, ok := strings.CutPrefix(s, "hex:"); ok {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errPackSyntax, err)
+		}
+		return string(b), nil
+	}
+	if len(s) > 0 && s[0] == '"' {
+		res, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", errPackSyntax, err)
+		}
+		return res, nil
+	}
+	return "", fmt.Errorf("%w: %q must be quoted or hex encoded", errPackSyntax, s)
+}
+
+// loads a pack file, or every pack in a directory (in file name order)
+func LoadPacks(path string) ([]PackEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*"+PackExt)); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var res []PackEntry
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := ReadPack(f, strings.TrimSuffix(filepath.Base(name), PackExt))
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, entries...)
+	}
+	return res, nil
+}
+
+// writes the mutations as pack lines of the given level
+func WritePack(w io.Writer, level config.LEVEL, muts []Mutation) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range muts {
+		if m.Type == CRLF {
+			continue // derived from TE and CL
+		}
+		fmt.Fprintf(bw, "%s %s %s %s", m.Type, level, strconv.Quote(m.Key), strconv.Quote(m.Val))
+		if len(m.Tags) > 0 {
+			fmt.Fprintf(bw, " %s", strings.Join(m.Tags, ","))
+		}
+		fmt.Fprintf(bw, " # %s\n", m.ID)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,98 @@
+package tests_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"smuggler/config"
+	"smuggler/smuggler/tests"
+	"strings"
+	"testing"
+)
+
+const pack = `# engagement findings
+TE B "Transfer-Encoding" "\x0bchunked" vtab,value
+TE * "Transfer-Encoding" " chunked\x00"   # nul
+CL E hex:436f6e74656e742d4c656e677468ff ""
+
+TE M "X: X\r\nTransfer-Encoding" " chunked" crlf
+`
+
+func TestReadPack(t *testing.T) {
+	entries, err := tests.ReadPack(strings.NewReader(pack), "eng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := []struct {
+		id   string
+		line string
+		tags []string
+	}{
+		{"eng-TE-B-001", "Transfer-Encoding:\x0bchunked", []string{"vtab", "value"}},
+		{"eng-TE-A-001", "Transfer-Encoding: chunked\x00", nil},
+		{"eng-CL-E-001", "Content-Length\xff:", nil},
+		{"eng-TE-M-001", "X: X\r\nTransfer-Encoding: chunked", []string{"crlf"}},
+	}
+	if len(entries) != len(table) {
+		t.Fatalf("Wanted: %d entries, Got: %d", len(table), len(entries))
+	}
+	for i, Case := range table {
+		e := entries[i]
+		if e.ID != Case.id || e.Line() != Case.line || !slices.Equal(e.Tags, Case.tags) {
+			t.Errorf("Wanted: %s %q %v, Got: %s %q %v", Case.id, Case.line, Case.tags, e.ID, e.Line(), e.Tags)
+		}
+	}
+}
+
+func TestReadPackErrors(t *testing.T) {
+	for _, line := range []string{
+		`XX B "a" "b"`,
+		`TE Z "a" "b"`,
+		`TE B a "b"`,
+		`TE B "a"`,
+		`TE B hex:zz "b"`,
+		`TE B "" "b"`,
+		`TE B "a "b"`,
+	} {
+		_, err := tests.ReadPack(strings.NewReader("# ok\n"+line), "bad")
+		if err == nil || !strings.HasPrefix(err.Error(), "bad:2: ") {
+			t.Errorf("%s: Wanted an error on line 2, Got: %v", line, err)
+		}
+	}
+	if _, err := tests.LoadPacks(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Wanted: %v, Got: %v", os.ErrNotExist, err)
+	}
+}
+
+func TestGeneratePacks(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "b.pack"), []byte(`TE B "Transfer-Encoding" " chunked"`+"\n"+`CL * "Content-Length\x00" ""`), 0644)
+	os.WriteFile(filepath.Join(dir, "a.pack"), []byte(`TE B "Transfer-Encoding" "\x00chunked"`), 0644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a pack"), 0644)
+
+	entries, err := tests.LoadPacks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := tests.Generator{}
+	g := tests.Generator{Packs: entries}
+
+	builtin := plain.Generate(tests.TE, config.B)
+	te := g.Generate(tests.TE, config.B)
+	if len(te) != len(builtin)+1 || te[len(te)-1].ID != "a-TE-B-001" { // b-TE-B-001 is built in
+		t.Errorf("Wanted the pack mutation after the built-in ones, Got: %+v", te[len(builtin):])
+	}
+	if cl := g.Generate(tests.CL, config.E); cl[len(cl)-1].ID != "b-CL-A-001" {
+		t.Errorf("Wanted a CL mutation for every level, Got: %+v", cl[len(cl)-1])
+	}
+	crlf := g.Generate(tests.CRLF, config.M)
+	if !slices.ContainsFunc(crlf, func(m tests.Mutation) bool { return m.Val == "A\r\nContent-Length\x00" }) {
+		t.Error("Wanted CRLF mutations derived from the pack")
+	}
+	// the IDs of the built-in CRLF mutations don't change when packs are loaded
+	if builtin := plain.Generate(tests.CRLF, config.M); !reflect.DeepEqual(crlf[:len(builtin)], builtin) {
+		t.Errorf("Wanted the built-in CRLF mutations first, Got: %+v", crlf[:len(builtin)])
+	}
+}
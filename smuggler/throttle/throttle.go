@@ -0,0 +1,178 @@
+// Package throttle keeps a scan polite: request rates (per host and overall), concurrent
+// requests per host, a jittered delay between requests and a backoff when a host answers 429
+// or 503. a Limiter is shared by every scan of a process, the hosts of concurrent targets are
+// throttled together.
+package throttle
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+type Config struct {
+	Rate       float64       // requests per second to a host, unlimited if zero
+	GlobalRate float64       // requests per second to all hosts, unlimited if zero
+	MaxConns   int           // concurrent requests to a host (each h1 request has its own connection), unlimited if zero
+	Delay      time.Duration // between two requests to a host
+	Jitter     time.Duration // random extra delay, up to Jitter
+}
+
+type Limiter struct {
+	cfg Config
+
+	global schedule
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// the start time of the next request
+type schedule struct {
+	mu    sync.Mutex
+	next  time.Time
+	until time.Time // backoff
+}
+
+type host struct {
+	schedule
+	sem     chan struct{} // nil if unlimited
+	backoff time.Duration
+}
+
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, hosts: make(map[string]*host)}
+}
+
+func (l *Limiter) host(name string) *host {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name = strings.ToLower(name)
+	h, ok := l.hosts[name]
+	if !ok {
+		h = &host{}
+		if l.cfg.MaxConns > 0 {
+			h.sem = make(chan struct{}, l.cfg.MaxConns)
+		}
+		l.hosts[name] = h
+	}
+	return h
+}
+
+// waits for a request to name (host[:port]) to be allowed, release must be called when the
+// request is done. a nil Limiter doesn't limit anything
+func (l *Limiter) Acquire(ctx context.Context, name string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	h := l.host(name)
+	if h.sem != nil {
+		select {
+		case h.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release = func() {
+		if h.sem != nil {
+			<-h.sem
+		}
+	}
+
+	interval := l.cfg.Delay
+	if l.cfg.Jitter > 0 {
+		interval += rand.N(l.cfg.Jitter)
+	}
+	if l.cfg.Rate > 0 {
+		interval = max(interval, time.Duration(float64(time.Second)/l.cfg.Rate))
+	}
+	if err := h.wait(ctx, interval); err != nil {
+		release()
+		return nil, err
+	}
+	if l.cfg.GlobalRate > 0 {
+		if err := l.global.wait(ctx, time.Duration(float64(time.Second)/l.cfg.GlobalRate)); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// reserves the next start time (interval after the previous one, after the backoff) and
+// sleeps until then
+func (s *schedule) wait(ctx context.Context, interval time.Duration) error {
+	s.mu.Lock()
+	now := time.Now()
+	start := now
+	if s.next.After(start) {
+		start = s.next
+	}
+	if s.until.After(start) {
+		start = s.until
+	}
+	s.next = start.Add(interval)
+	s.mu.Unlock()
+
+	if d := start.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// feeds the response of a request to name to the backoff: on 429 or 503 the host isn't sent
+// anything for Retry-After (or an exponential backoff), another status resets it
+func (l *Limiter) Observe(name string, resp *http.Response) {
+	if l == nil || resp == nil {
+		return
+	}
+	h := l.host(name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		h.backoff = 0
+		return
+	}
+	h.backoff = min(max(h.backoff*2, minBackoff), maxBackoff)
+	wait := h.backoff
+	if ra := retryAfter(resp.Header.Get("Retry-After")); ra > 0 {
+		wait = min(ra, maxBackoff*5)
+	}
+	if until := time.Now().Add(wait); until.After(h.until) {
+		h.until = until
+	}
+}
+
+// Retry-After in seconds or as an HTTP date, zero if missing or invalid
+func retryAfter(v string) time.Duration {
+	if len(v) == 0 {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
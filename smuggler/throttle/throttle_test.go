@@ -0,0 +1,96 @@
+package throttle_test
+
+import (
+	"context"
+	"net/http"
+	"smuggler/smuggler/throttle"
+	"sync"
+	"testing"
+	"time"
+)
+
+func acquire(t *testing.T, l *throttle.Limiter, host string) {
+	release, err := l.Acquire(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestRate(t *testing.T) {
+	table := []struct {
+		name  string
+		cfg   throttle.Config
+		hosts []string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"unlimited", throttle.Config{}, []string{"a", "a", "a", "a", "a"}, 0, time.Millisecond * 20},
+		{"per host", throttle.Config{Rate: 20}, []string{"a", "a", "a", "a", "a"}, time.Millisecond * 200, time.Millisecond * 300},
+		{"other hosts", throttle.Config{Rate: 20}, []string{"a", "b", "c", "d", "e"}, 0, time.Millisecond * 20},
+		{"global", throttle.Config{GlobalRate: 20}, []string{"a", "b", "c", "d", "e"}, time.Millisecond * 200, time.Millisecond * 300},
+		{"delay", throttle.Config{Delay: time.Millisecond * 50}, []string{"a", "A", "a", "a", "a"}, time.Millisecond * 200, time.Millisecond * 300},
+		{"jitter", throttle.Config{Jitter: time.Millisecond * 50}, []string{"a", "a", "a", "a", "a"}, 0, time.Millisecond * 250},
+	}
+	for _, Case := range table {
+		t.Run(Case.name, func(t *testing.T) {
+			l := throttle.New(Case.cfg)
+			start := time.Now()
+			for _, h := range Case.hosts {
+				acquire(t, l, h)
+			}
+			if d := time.Since(start); d < Case.min || d > Case.max {
+				t.Errorf("Wanted: between %v and %v, Got: %v", Case.min, Case.max, d)
+			}
+		})
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	l := throttle.New(throttle.Config{MaxConns: 2})
+	r1, _ := l.Acquire(context.Background(), "a")
+	r2, _ := l.Acquire(context.Background(), "a")
+	acquire(t, l, "b") // other hosts aren't affected
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "a"); err == nil {
+		t.Fatal("Wanted: the third request to wait")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		acquire(t, l, "a")
+	}()
+	r1()
+	wg.Wait()
+	r2()
+}
+
+func TestBackoff(t *testing.T) {
+	l := throttle.New(throttle.Config{})
+	l.Observe("a", &http.Response{StatusCode: http.StatusOK})
+	acquire(t, l, "a")
+
+	l.Observe("a", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "a"); err == nil {
+		t.Error("Wanted: requests to wait for Retry-After")
+	}
+	acquire(t, l, "b")
+
+	// exponential without Retry-After
+	l.Observe("c", &http.Response{StatusCode: http.StatusServiceUnavailable})
+	start := time.Now()
+	acquire(t, l, "c")
+	if d := time.Since(start); d < time.Millisecond*900 {
+		t.Errorf("Wanted: a backoff of about 1s, Got: %v", d)
+	}
+
+	var l2 *throttle.Limiter // no limits
+	l2.Observe("a", &http.Response{StatusCode: http.StatusTooManyRequests})
+	acquire(t, l2, "a")
+}
@@ -0,0 +1,147 @@
+// Package tlsconf builds the TLS client configuration shared by every client of a scan:
+// h1.Transport, h1.RawClient, h2.Transport and the cookie-fetching http.Client.
+package tlsconf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Options struct {
+	KeyLogFile string // session secrets are appended to it (NSS key log format), $SSLKEYLOGFILE if empty
+	ServerName string // SNI, the host of the target if empty
+
+	MinVersion   uint16   // tls.VersionTLS10...
+	MaxVersion   uint16   // Go's default if zero
+	CipherSuites []uint16 // only used up to TLS 1.2, Go's default if empty
+
+	ALPN         []string          // overrides the protocols offered by each client (h2 or http/1.1)
+	Certificates []tls.Certificate // client certificates for mTLS targets
+}
+
+// client config, alpn is offered unless the options override it. the certificate of the target
+// isn't verified (the nil Options are the defaults)
+func (o *Options) Config(alpn ...string) *tls.Config {
+	if o == nil {
+		o = &Options{}
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         o.ServerName,
+		MinVersion:         o.MinVersion,
+		MaxVersion:         o.MaxVersion,
+		CipherSuites:       o.CipherSuites,
+		Certificates:       o.Certificates,
+		NextProtos:         alpn,
+	}
+	if len(o.ALPN) > 0 {
+		cfg.NextProtos = o.ALPN
+	}
+	if w := keyLog(o.keyLogFile()); w != nil {
+		cfg.KeyLogWriter = w
+	}
+	return cfg
+}
+
+func (o *Options) keyLogFile() string {
+	if len(o.KeyLogFile) > 0 {
+		return o.KeyLogFile
+	}
+	return os.Getenv("SSLKEYLOGFILE")
+}
+
+// key log files are opened once and shared by every connection
+var (
+	keyLogMu    sync.Mutex
+	keyLogFiles = make(map[string]io.Writer)
+)
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// nil if path is empty or can't be opened, a handshake must not fail because of the key log
+func keyLog(path string) io.Writer {
+	if len(path) == 0 {
+		return nil
+	}
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
+
+	if w, ok := keyLogFiles[path]; ok {
+		return w
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Warn().Err(err).Msg("TLS key logging disabled")
+		keyLogFiles[path] = nil // warn once
+		return nil
+	}
+	w := &lockedWriter{w: f}
+	keyLogFiles[path] = w
+	return w
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parses a version like 1.2, zero if s is empty
+func ParseVersion(s string) (uint16, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	if v, ok := versions[s]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version: %s: valid versions: 1.0,1.1,1.2,1.3", s)
+}
+
+// parses a comma separated list of cipher suite names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256),
+// insecure suites are accepted
+func ParseCipherSuites(s string) ([]uint16, error) {
+	ids := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		ids[cs.Name] = cs.ID
+	}
+
+	var res []uint16
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); len(name) == 0 {
+			continue
+		}
+		id, ok := ids[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		res = append(res, id)
+	}
+	return res, nil
+}
+
+// splits a comma separated list of protocols
+func ParseALPN(s string) []string {
+	var res []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			res = append(res, p)
+		}
+	}
+	return res
+}
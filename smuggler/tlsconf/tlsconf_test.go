@@ -0,0 +1,112 @@
+package tlsconf_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"smuggler/smuggler/h1"
+	"smuggler/smuggler/tlsconf"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	if v, err := tlsconf.ParseVersion("1.2"); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("Wanted: %d, Got: %d (%v)", tls.VersionTLS12, v, err)
+	}
+	if v, err := tlsconf.ParseVersion(""); err != nil || v != 0 {
+		t.Errorf("Wanted: 0, Got: %d (%v)", v, err)
+	}
+	if _, err := tlsconf.ParseVersion("1.4"); err == nil {
+		t.Error("Wanted an error for an unknown version")
+	}
+
+	cs, err := tlsconf.ParseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls_rsa_with_rc4_128_sha")
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_RC4_128_SHA}
+	if err != nil || !slices.Equal(cs, want) {
+		t.Errorf("Wanted: %v, Got: %v (%v)", want, cs, err)
+	}
+	if _, err := tlsconf.ParseCipherSuites("TLS_NOPE"); err == nil {
+		t.Error("Wanted an error for an unknown cipher suite")
+	}
+
+	if got := tlsconf.ParseALPN(" h2, http/1.1,"); !slices.Equal(got, []string{"h2", "http/1.1"}) {
+		t.Errorf("Wanted: [h2 http/1.1], Got: %v", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	var defaults *tlsconf.Options
+	cfg := defaults.Config("h2")
+	if !cfg.InsecureSkipVerify || !slices.Equal(cfg.NextProtos, []string{"h2"}) || len(cfg.ServerName) > 0 {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+
+	opts := &tlsconf.Options{ServerName: "example.com", ALPN: []string{"http/1.1"}, MaxVersion: tls.VersionTLS12}
+	cfg = opts.Config("h2")
+	if cfg.ServerName != "example.com" || !slices.Equal(cfg.NextProtos, []string{"http/1.1"}) || cfg.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("options not applied: %+v", cfg)
+	}
+}
+
+// status of a GET sent with h1.RawClient
+func get(srv *httptest.Server, opts *tlsconf.Options) (int, error) {
+	u, _ := url.Parse(srv.URL)
+	c, err := h1.NewClient(u, opts, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(time.Second * 2))
+	resps, err := c.SendPipelinedRequests(fmt.Sprintf("GET / HTTP/1.1\r\nHost: %s\r\n\r\n", u.Host))
+	if err != nil {
+		return 0, err
+	}
+	return resps[0].StatusCode, nil
+}
+
+func TestKeyLog(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+	t.Setenv("SSLKEYLOGFILE", path)
+	if _, err := get(srv, nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "CLIENT_") {
+		t.Errorf("Wanted NSS key log lines, Got: %q", b)
+	}
+
+	// a key log that can't be opened doesn't break the handshake
+	if _, err := get(srv, &tlsconf.Options{KeyLogFile: filepath.Join(path, "nope")}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Certs", fmt.Sprint(len(r.TLS.PeerCertificates)))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	if _, err := get(srv, nil); err == nil {
+		t.Error("Wanted an error without a client certificate")
+	}
+	opts := &tlsconf.Options{Certificates: srv.TLS.Certificates}
+	if status, err := get(srv, opts); err != nil || status != http.StatusOK {
+		t.Errorf("Wanted: %d, Got: %d (%v)", http.StatusOK, status, err)
+	}
+}
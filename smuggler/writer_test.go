@@ -1,18 +1,41 @@
 package smuggler_test
 
 import (
+	"path/filepath"
+	"smuggler/config"
 	"smuggler/smuggler"
+	"smuggler/smuggler/tests"
 	"testing"
 )
 
-func TestWriteBasic(t *testing.T) {
-	smuggler.WriteBasic()
-}
+func TestExportPacks(t *testing.T) {
+	dir := t.TempDir()
+	if err := smuggler.ExportPacks(dir); err != nil {
+		t.Fatal(err)
+	}
 
-func TestWriteDouble(t *testing.T) {
-	smuggler.WriteDouble()
-}
+	g := tests.Generator{}
+	for _, level := range []config.LEVEL{config.B, config.M, config.E} {
+		t.Run(smuggler.PackNames[level], func(t *testing.T) {
+			entries, err := tests.LoadPacks(filepath.Join(dir, smuggler.PackNames[level]+tests.PackExt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := append(g.Generate(tests.TE, level), g.Generate(tests.CL, level)...)
+			if len(entries) != len(want) {
+				t.Fatalf("Wanted: %d mutations, Got: %d", len(want), len(entries))
+			}
+			for i, e := range entries {
+				if e.Type != want[i].Type || e.Key != want[i].Key || e.Val != want[i].Val || e.Level != level {
+					t.Errorf("%s: Wanted: %q, Got: %q", want[i].ID, want[i].Line(), e.Line())
+				}
+			}
 
-func TestWriteExhaustive(t *testing.T) {
-	smuggler.WriteExhaustive()
+			// loading the built-in mutations as a pack adds nothing
+			withPack := tests.Generator{Packs: entries}
+			if got := len(withPack.Generate(tests.TE, level)); got != len(g.Generate(tests.TE, level)) {
+				t.Errorf("Wanted duplicates to be dropped, Got: %d mutations", got)
+			}
+		})
+	}
 }